@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const browsePageSize = 20
+
+// browser is the interactive rows browser model: selected rows, cursor position, opened details and edited filter
+type browser struct {
+	ctx context.Context
+	db  *LiteArgsDb
+	// command is rendered for the selected row on retry; retry is disabled if it is empty
+	command string
+	shell   string
+	// force allows to retry rows locked after the successful attempt
+	force bool
+	// editing is set while the filter input is open; input is applied to the filter on enter
+	editing  bool
+	input    string
+	filter   string
+	rows     []map[string]any
+	pks      []any
+	cursor   int
+	height   int
+	detail   bool
+	retrying bool
+	message  string
+	err      error
+}
+
+// retriedMsg is sent when retry of the row command finishes
+type retriedMsg struct {
+	pk      any
+	summary execSummary
+}
+
+// reload selects all rows (including succeed, disabled and locked ones) matching the current filter together with their state
+func (b *browser) reload() error {
+	where := b.filter
+	if where == "" {
+		where = "1 = 1"
+	}
+	columns := make([]string, 0, len(stateColumns))
+	for _, column := range stateColumns {
+		columns = append(columns, column.name)
+	}
+	rows, pks, err := b.db.Filter(LiteArgsDbFilter{
+		WhereRaw:        where,
+		PreserveOrder:   true,
+		IncludeDisabled: true,
+		IncludeLocked:   true,
+		StateColumns:    columns,
+	})
+	if err != nil {
+		return err
+	}
+	b.rows, b.pks = rows, pks
+	b.cursor = min(b.cursor, max(len(rows)-1, 0))
+	return nil
+}
+
+func (b *browser) Init() tea.Cmd { return nil }
+
+// Update handles keys: j/down, k/up (move), o/enter (toggle details), / (edit filter), r (retry selected row), q (quit)
+func (b *browser) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		b.height = msg.Height
+	case retriedMsg:
+		b.retrying = false
+		if msg.summary.dbErrors > 0 {
+			b.err = fmt.Errorf("failed to update row state: rowid=%v", msg.pk)
+			return b, tea.Quit
+		}
+		b.message = fmt.Sprintf("retried rowid=%v: succeed=%v", msg.pk, msg.summary.succeed > 0)
+		if msg.summary.skipped > 0 {
+			b.message = fmt.Sprintf("retry result isn't recorded for the locked row: rowid=%v", msg.pk)
+		}
+		if b.err = b.reload(); b.err != nil {
+			return b, tea.Quit
+		}
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return b, tea.Quit
+		}
+		if b.editing {
+			return b, b.edit(msg)
+		}
+		b.message = ""
+		switch msg.String() {
+		case "q":
+			return b, tea.Quit
+		case "j", "down":
+			b.cursor = min(b.cursor+1, max(len(b.rows)-1, 0))
+		case "k", "up":
+			b.cursor = max(b.cursor-1, 0)
+		case "o", "enter":
+			b.detail = !b.detail
+		case "/":
+			b.editing, b.input = true, b.filter
+		case "r":
+			return b, b.retry()
+		default:
+			b.message = fmt.Sprintf("unknown input: '%v'", msg)
+		}
+	}
+	return b, nil
+}
+
+// edit applies key to the filter input and reloads rows when the input is submitted
+func (b *browser) edit(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		b.editing = false
+	case tea.KeyEnter:
+		previous := b.filter
+		b.editing, b.filter, b.cursor = false, b.input, 0
+		if err := b.reload(); err != nil {
+			b.filter, b.message = previous, err.Error()
+			if b.err = b.reload(); b.err != nil {
+				return tea.Quit
+			}
+		}
+	case tea.KeyBackspace:
+		if runes := []rune(b.input); len(runes) > 0 {
+			b.input = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		b.input += string(msg.Runes)
+	}
+	return nil
+}
+
+// retry returns command executing the command template for the selected row and updating its state
+func (b *browser) retry() tea.Cmd {
+	switch {
+	case b.command == "":
+		b.message = "retry is disabled: command isn't set"
+		return nil
+	case len(b.rows) == 0:
+		b.message = "no row selected"
+		return nil
+	case b.retrying:
+		b.message = "retry is already running"
+		return nil
+	case b.rows[b.cursor]["locked"] == int64(1) && !b.force:
+		b.message = fmt.Sprintf("row is locked after the successful attempt, use --force to retry it: rowid=%v", b.pks[b.cursor])
+		return nil
+	}
+	commands, err := render(b.command, b.rows[b.cursor:b.cursor+1])
+	if err != nil {
+		b.message = err.Error()
+		return nil
+	}
+	pks := b.pks[b.cursor : b.cursor+1]
+	options := execOptions{parallelism: 1, shell: b.shell, force: b.force}
+	b.retrying, b.message = true, fmt.Sprintf("retrying rowid=%v...", pks[0])
+	return func() tea.Msg {
+		return retriedMsg{pk: pks[0], summary: execute(b.ctx, b.db, pks, commands, options)}
+	}
+}
+
+func rowStatus(row map[string]any) string {
+	switch {
+	case row["succeed"] == int64(1):
+		return "succeed"
+	case row["running"] == int64(1):
+		return "running"
+	case row["attempts"] == int64(0):
+		return "pending"
+	default:
+		return "failed"
+	}
+}
+
+// View renders page of rows around the cursor together with details of the selected row
+func (b *browser) View() string {
+	var w strings.Builder
+	_, _ = fmt.Fprintf(&w, "filter: '%v', rows: %v\n", b.filter, len(b.rows))
+	pageSize := browsePageSize
+	if b.height > 0 {
+		pageSize = max(b.height-3, 1)
+	}
+	start := max(0, b.cursor-pageSize/2)
+	for i := start; i < min(len(b.rows), start+pageSize); i++ {
+		row := b.rows[i]
+		marker := " "
+		if i == b.cursor {
+			marker = ">"
+		}
+		values := make([]string, 0, len(b.db.Columns())-1)
+		for _, column := range b.db.Columns()[1:] {
+			values = append(values, fmt.Sprint(exportValue(row[column])))
+		}
+		_, _ = fmt.Fprintf(&w, "%v %v [%v] %v\n", marker, b.pks[i], rowStatus(row), strings.Join(values, " "))
+		if i == b.cursor && b.detail {
+			columns := b.db.Columns()
+			for _, column := range stateColumns {
+				columns = append(columns, column.name)
+			}
+			_ = printRow(&w, columns, row, false)
+		}
+	}
+	if b.message != "" {
+		_, _ = fmt.Fprintln(&w, b.message)
+	}
+	if b.editing {
+		_, _ = fmt.Fprintf(&w, "filter: %v", b.input)
+	} else {
+		_, _ = fmt.Fprint(&w, "j/k: move, o: details, /: filter, r: retry, q: quit")
+	}
+	return w.String()
+}
+
+// browse runs interactive browser reading keys from in and rendering its view to out
+func browse(ctx context.Context, in io.Reader, out io.Writer, b *browser) error {
+	b.ctx = ctx
+	if err := b.reload(); err != nil {
+		return err
+	}
+	if _, err := tea.NewProgram(b, tea.WithContext(ctx), tea.WithInput(in), tea.WithOutput(out)).Run(); err != nil {
+		return fmt.Errorf("failed to run browser: %w", err)
+	}
+	return b.err
+}
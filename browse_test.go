@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+)
+
+// press sends keys to the browser running produced commands synchronously and returns true if browser quit
+func press(t *testing.T, b *browser, keys ...string) bool {
+	t.Helper()
+	for _, key := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		switch key {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		case "backspace":
+			msg = tea.KeyMsg{Type: tea.KeyBackspace}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		}
+		_, cmd := b.Update(msg)
+		for cmd != nil {
+			result := cmd()
+			if _, ok := result.(tea.QuitMsg); ok {
+				return true
+			}
+			_, cmd = b.Update(result)
+		}
+	}
+	return false
+}
+
+func TestBrowserUpdate(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name", "code"}, []string{"n-1", "0"}, []string{"n-2", "1"}, []string{"n-3", "0"})
+	b := &browser{ctx: context.Background(), db: db, command: "exit {{ .code }}", shell: "sh"}
+	require.Nil(t, b.reload())
+	require.Len(t, b.rows, 3)
+
+	require.False(t, press(t, b, "k", "j", "down", "j", "j"))
+	require.Equal(t, 2, b.cursor)
+
+	require.False(t, press(t, b, "/", "n", "a", "m", "e", " ", "<", ">", " ", "'", "n", "-", "3", "'", "enter"))
+	require.Equal(t, "name <> 'n-3'", b.filter)
+	require.Equal(t, 0, b.cursor)
+	require.Len(t, b.rows, 2)
+
+	require.False(t, press(t, b, "r"))
+	require.Equal(t, "retried rowid=1: succeed=true", b.message)
+	require.False(t, press(t, b, "j", "r"))
+	require.Equal(t, "retried rowid=2: succeed=false", b.message)
+
+	_, row, err := db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, "succeed", rowStatus(row))
+	_, row, err = db.Get(int64(2))
+	require.Nil(t, err)
+	require.Equal(t, "failed", rowStatus(row))
+	require.Len(t, b.rows, 2)
+
+	require.False(t, press(t, b, "/", "backspace", "x", "enter"))
+	require.Equal(t, "name <> 'n-3'", b.filter)
+	require.Contains(t, b.message, "unterminated quote")
+
+	require.False(t, press(t, b, "/", "q", "esc"))
+	require.Equal(t, "name <> 'n-3'", b.filter)
+	require.False(t, b.editing)
+
+	require.True(t, press(t, b, "q"))
+}
+
+func TestBrowserIncludesDisabledAndLocked(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name", "enabled"}, []string{"n-1", "1"}, []string{"n-2", "0"})
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, Lock: true}))
+	b := &browser{ctx: context.Background(), db: db, command: "exit 1", shell: "sh"}
+	require.Nil(t, b.reload())
+	require.Equal(t, []any{int64(1), int64(2)}, b.pks)
+	require.Equal(t, int64(1), b.rows[0]["locked"])
+
+	require.False(t, press(t, b, "r"))
+	require.Equal(t, "row is locked after the successful attempt, use --force to retry it: rowid=1", b.message)
+	_, row, err := db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, int64(1), row["succeed"])
+	require.Equal(t, int64(1), row["attempts"])
+
+	b.force = true
+	require.False(t, press(t, b, "r"))
+	require.Equal(t, "retried rowid=1: succeed=false", b.message)
+	require.Equal(t, "failed", rowStatus(b.rows[0]))
+}
+
+func TestBrowse(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	var output bytes.Buffer
+	b := &browser{db: db}
+	require.Nil(t, browse(context.Background(), iotest.OneByteReader(strings.NewReader("joq")), &output, b))
+	require.Equal(t, 1, b.cursor)
+	require.True(t, b.detail)
+	require.Contains(t, output.String(), "> 2 [pending] n-2")
+	require.Contains(t, output.String(), "last_state:")
+}
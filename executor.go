@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type CommandResult struct {
+	Succeed  bool
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	// Interrupted holds context error if the command was stopped before its completion
+	Interrupted error
+}
+
+// state returns ok, failed, interrupted (by cancellation) or timeout (by context deadline) state of the command
+func (r CommandResult) state() string {
+	switch {
+	case errors.Is(r.Interrupted, context.DeadlineExceeded):
+		return "timeout"
+	case r.Interrupted != nil:
+		return "interrupted"
+	case r.Succeed:
+		return "ok"
+	default:
+		return "failed"
+	}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// KillPolicy sends Signal to the cancelled command and kills it after Grace period (zero value sends SIGINT and kills immediately)
+type KillPolicy struct {
+	Signal syscall.Signal
+	Grace  time.Duration
+}
+
+var killSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+func killSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	signal, ok := killSignals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported kill signal: '%v'", name)
+	}
+	return signal, nil
+}
+
+// Executor runs rendered commands and captures their results
+type Executor interface {
+	Run(ctx context.Context, shell string, command string) CommandResult
+}
+
+// LocalExecutor runs commands as subprocesses of the liteargs process
+type LocalExecutor struct {
+	// Env contains KEY=VALUE pairs added to the environment of the liteargs process
+	Env  []string
+	Dir  string
+	Kill KillPolicy
+	// MergeStderr writes stderr into the same buffer as stdout preserving order of the output
+	MergeStderr    bool
+	MaxOutputBytes int
+}
+
+func (e LocalExecutor) Run(ctx context.Context, shell string, command string) CommandResult {
+	cmd := exec.Command(shell, "-c", command)
+	if len(e.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Env...)
+	}
+	cmd.Dir = e.Dir
+	return run(ctx, cmd, command, e.Kill, e.MergeStderr, e.MaxOutputBytes)
+}
+
+// DockerExecutor runs every command in the new container created from the image
+type DockerExecutor struct {
+	Image          string
+	Env            []string
+	Dir            string
+	Kill           KillPolicy
+	MergeStderr    bool
+	MaxOutputBytes int
+}
+
+func (e DockerExecutor) args(shell string, command string) []string {
+	args := []string{"run", "--rm"}
+	for _, env := range e.Env {
+		args = append(args, "-e", env)
+	}
+	if e.Dir != "" {
+		args = append(args, "-w", e.Dir)
+	}
+	return append(args, e.Image, shell, "-c", command)
+}
+
+func (e DockerExecutor) Run(ctx context.Context, shell string, command string) CommandResult {
+	return run(ctx, exec.Command("docker", e.args(shell, command)...), command, e.Kill, e.MergeStderr, e.MaxOutputBytes)
+}
+
+type outputBuffer interface {
+	io.Writer
+	String() string
+}
+
+// ringBuffer keeps only last size bytes written to it, so output of chatty commands doesn't grow memory without bound
+type ringBuffer struct {
+	data []byte
+	size int
+	// start is position of the oldest byte once the buffer is full
+	start int
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= b.size {
+		b.data, b.start = append(b.data[:0], p[n-b.size:]...), 0
+		return n, nil
+	}
+	if free := b.size - len(b.data); free > 0 {
+		appended := min(free, len(p))
+		b.data, p = append(b.data, p[:appended]...), p[appended:]
+	}
+	for len(p) > 0 {
+		copied := copy(b.data[b.start:], p)
+		b.start, p = (b.start+copied)%b.size, p[copied:]
+	}
+	return n, nil
+}
+
+func (b *ringBuffer) String() string {
+	return string(b.data[b.start:]) + string(b.data[:b.start])
+}
+
+// newOutputBuffer returns buffer of the command output stream which keeps only last maxBytes (--max-output-bytes) when it is positive
+func newOutputBuffer(maxBytes int) outputBuffer {
+	if maxBytes > 0 {
+		return &ringBuffer{data: make([]byte, 0, maxBytes), size: maxBytes}
+	}
+	return &bytes.Buffer{}
+}
+
+// run starts the process prepared for the command and waits for its completion or context cancellation
+func run(ctx context.Context, cmd *exec.Cmd, command string, kill KillPolicy, mergeStderr bool, maxOutputBytes int) CommandResult {
+	stdout := newOutputBuffer(maxOutputBytes)
+	stderr := newOutputBuffer(maxOutputBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if mergeStderr {
+		cmd.Stderr = stdout
+	}
+
+	startTime := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		errorLog("failed to execute command: %v, err=%v", command, err)
+		return CommandResult{ExitCode: -1}
+	}
+	traceLog("command started: %v", command)
+
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- cmd.Wait() }()
+
+	result := CommandResult{ExitCode: -1}
+	select {
+	case err = <-waitCh:
+		result.ExitCode = exitCode(err)
+		if err == nil {
+			result.Succeed = true
+			okLog("command succeed: %v, elapsed=%v, stdout=%v", command, time.Since(startTime), stdout.String())
+		} else {
+			errorLog("command failed: %v, err=%v", command, err)
+		}
+	case <-ctx.Done():
+		traceLog("command interrupted: %v", command)
+		result.Interrupted = ctx.Err()
+		signal := kill.Signal
+		if signal == 0 {
+			signal = syscall.SIGINT
+		}
+		err = cmd.Process.Signal(signal)
+		if err != nil {
+			traceLog("command interruption failed: %v, err=%v", command, err)
+		}
+		if signal != syscall.SIGKILL && kill.Grace > 0 {
+			select {
+			case <-waitCh:
+			case <-time.After(kill.Grace):
+				traceLog("command not stopped after grace period, killing: %v", command)
+				_ = cmd.Process.Kill()
+				<-waitCh
+			}
+		} else {
+			_ = cmd.Process.Kill()
+			<-waitCh
+		}
+	}
+	result.Duration = time.Since(startTime)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
@@ -0,0 +1,1089 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLocalExecutor(t *testing.T) {
+	captureLogs(t)
+	dir := t.TempDir()
+	result := LocalExecutor{Env: []string{"LITEARGS_TEST=value"}, Dir: dir}.Run(context.Background(), "sh", "echo $LITEARGS_TEST; pwd; exit 2")
+	require.False(t, result.Succeed)
+	require.Equal(t, 2, result.ExitCode)
+	require.Equal(t, "value\n"+dir+"\n", result.Stdout)
+}
+
+func TestDockerExecutorArgs(t *testing.T) {
+	executor := DockerExecutor{Image: "alpine", Env: []string{"A=1", "B=2"}, Dir: "/work"}
+	require.Equal(t,
+		[]string{"run", "--rm", "-e", "A=1", "-e", "B=2", "-w", "/work", "alpine", "sh", "-c", "echo ok"},
+		executor.args("sh", "echo ok"),
+	)
+}
+
+func TestDockerExecutor(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("docker daemon is not available")
+	}
+	captureLogs(t)
+	result := DockerExecutor{Image: "alpine", Env: []string{"LITEARGS_TEST=value"}}.Run(context.Background(), "sh", "echo $LITEARGS_TEST; cat /etc/alpine-release >/dev/null")
+	require.True(t, result.Succeed)
+	require.Equal(t, "value\n", result.Stdout)
+}
+
+func TestLocalExecutorKillGrace(t *testing.T) {
+	captureLogs(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	executor := LocalExecutor{Kill: KillPolicy{Signal: syscall.SIGTERM, Grace: 500 * time.Millisecond}}
+	result := executor.Run(ctx, "sh", "trap '' TERM; while true; do sleep 0.05; done")
+	require.False(t, result.Succeed)
+	require.GreaterOrEqual(t, result.Duration, 600*time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result = executor.Run(ctx, "sh", "trap 'echo cleanup; exit 3' TERM; while true; do sleep 0.05; done")
+	require.Equal(t, "cleanup\n", result.Stdout)
+	require.Less(t, result.Duration, 500*time.Millisecond)
+
+	_, err := killSignal("term")
+	require.Nil(t, err)
+	_, err = killSignal("SIGHUP")
+	require.NotNil(t, err)
+}
+
+func TestLocalExecutorMergeStderr(t *testing.T) {
+	captureLogs(t)
+	result := LocalExecutor{MergeStderr: true}.Run(context.Background(), "sh", "echo out; echo done >&2")
+	require.Equal(t, "out\ndone\n", result.Stdout)
+	require.Equal(t, "", result.Stderr)
+	require.True(t, successCriteria{stdoutRegex: regexp.MustCompile(`(?m)^done$`)}.check(result))
+	require.False(t, successCriteria{stdoutRegex: regexp.MustCompile(`(?m)^done$`)}.check(LocalExecutor{}.Run(context.Background(), "sh", "echo out; echo done >&2")))
+}
+
+func TestRingBuffer(t *testing.T) {
+	buffer := newOutputBuffer(8).(*ringBuffer)
+	expected := ""
+	for _, chunk := range []string{"abc", "defgh", "ij", "klmnopqrstuvwxyz", "0", "12345"} {
+		n, err := buffer.Write([]byte(chunk))
+		require.Nil(t, err)
+		require.Equal(t, len(chunk), n)
+		expected += chunk
+		require.Equal(t, expected[max(0, len(expected)-8):], buffer.String())
+		require.Equal(t, 8, cap(buffer.data))
+	}
+}
+
+func TestLocalExecutorMaxOutputBytes(t *testing.T) {
+	captureLogs(t)
+	result := LocalExecutor{MaxOutputBytes: 16}.Run(context.Background(), "sh", "seq 1 200000; echo error >&2")
+	require.True(t, result.Succeed)
+	require.Equal(t, "199998\n199999\n200000\n"[5:], result.Stdout)
+	require.Equal(t, "error\n", result.Stderr)
+
+	result = LocalExecutor{MaxOutputBytes: 16, MergeStderr: true}.Run(context.Background(), "sh", "seq 1 200000; echo error >&2")
+	require.Equal(t, "199999\n200000\nerror\n"[4:], result.Stdout)
+}
+
+func TestExecuteNoUpdate(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", noUpdate: true})
+	require.Equal(t, execSummary{succeed: 2}, summary)
+
+	var attempts int
+	require.Nil(t, db.db.QueryRow(`SELECT SUM(attempts) FROM liteargs`).Scan(&attempts))
+	require.Equal(t, 0, attempts)
+	rows, _, err = db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Len(t, rows, 2)
+}
+
+func TestExecuteEmitResults(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"3"}, []string{"0"}, []string{"5"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo out-{{ .code }}; echo err-{{ .code }} >&2; exit {{ .code }}", rows)
+	require.Nil(t, err)
+
+	var results lockedBuffer
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 4, shell: "sh", results: &results})
+	require.Equal(t, execSummary{succeed: 2, failed: 2}, summary)
+
+	lines := strings.Split(strings.TrimSpace(results.String()), "\n")
+	require.Len(t, lines, 4)
+	emitted := make(map[int64]emittedResult)
+	for _, line := range lines {
+		var result struct {
+			emittedResult
+			RowId int64 `json:"rowid"`
+		}
+		require.Nil(t, json.Unmarshal([]byte(line), &result))
+		emitted[result.RowId] = result.emittedResult
+	}
+	require.Len(t, emitted, 4)
+	for rowId, code := range map[int64]int{1: 0, 2: 3, 3: 0, 4: 5} {
+		require.Equal(t, code == 0, emitted[rowId].Succeed)
+		require.Equal(t, code, emitted[rowId].ExitCode)
+		require.Equal(t, fmt.Sprintf("out-%v\n", code), emitted[rowId].Stdout)
+		require.Equal(t, fmt.Sprintf("err-%v\n", code), emitted[rowId].Stderr)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	captureLogs(t)
+	calls := 0
+	err := retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return ErrLocked
+		}
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		return ErrLocked
+	})
+	require.ErrorIs(t, err, ErrLocked)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		return errors.New("no such table: liteargs")
+	})
+	require.EqualError(t, err, "no such table: liteargs")
+	require.Equal(t, 1, calls)
+
+	calls = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	startTime := time.Now()
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = retry(ctx, 3, time.Hour, func() error {
+		calls++
+		return ErrLocked
+	})
+	require.ErrorIs(t, err, ErrLocked)
+	require.Equal(t, 1, calls)
+	require.Less(t, time.Since(startTime), time.Second)
+}
+
+func TestExecuteDbError(t *testing.T) {
+	captureLogs(t)
+	previousBackoff := updateBackoff
+	updateBackoff = time.Millisecond
+	t.Cleanup(func() { updateBackoff = previousBackoff })
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	_, err = db.db.Exec("DROP TABLE liteargs")
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"})
+	require.Equal(t, execSummary{dbErrors: 1}, summary)
+
+	summary = execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", keepGoing: true})
+	require.Equal(t, execSummary{dbErrors: 2}, summary)
+
+	// persistently locked row is retried and marked with the db_error state
+	db = testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	_, err = db.db.Exec(`CREATE TRIGGER locked BEFORE UPDATE OF succeed ON liteargs WHEN old.rowid = 2 BEGIN SELECT RAISE(ABORT, 'database is locked'); END`)
+	require.Nil(t, err)
+	executor := &fakeExecutor{}
+	summary = execute(context.Background(), db, pks, []string{"n-1 ok", "n-2 fail"}, execOptions{parallelism: 1, executor: executor, keepGoing: true, maxAttempts: 3})
+	require.Equal(t, execSummary{succeed: 1, dbErrors: 1}, summary)
+	require.Len(t, executor.commands, 2)
+	_, row, err := db.Get(int64(2))
+	require.Nil(t, err)
+	require.Equal(t, dbErrorState, row["last_state"])
+	require.Equal(t, int64(0), row["attempts"])
+}
+
+type fakeExecutor struct {
+	lock     sync.Mutex
+	commands []string
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, shell string, command string) CommandResult {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.commands = append(e.commands, command)
+	return CommandResult{Succeed: strings.HasSuffix(command, "ok"), Stdout: command}
+}
+
+func TestExecuteExecutor(t *testing.T) {
+	db := testDb(t, []string{"status"}, []string{"ok"}, []string{"fail"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("check {{ .status }}", rows)
+	require.Nil(t, err)
+
+	executor := &fakeExecutor{}
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, executor: executor})
+	require.Equal(t, execSummary{succeed: 1, failed: 1}, summary)
+	require.Equal(t, []string{"check ok", "check fail"}, executor.commands)
+
+	rows, _, err = db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(2), "status": "fail"}}, rows)
+}
+
+type countingExecutor struct {
+	runs atomic.Int32
+}
+
+func (e *countingExecutor) Run(ctx context.Context, shell string, command string) CommandResult {
+	e.runs.Add(1)
+	return CommandResult{Succeed: !strings.HasSuffix(command, "0")}
+}
+
+func TestExecuteChunks(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"id"})
+	rows := make([][]any, 1000)
+	for i := range rows {
+		rows[i] = []any{strconv.Itoa(i + 1)}
+	}
+	_, err := db.InsertBatch(rows, "", nil)
+	require.Nil(t, err)
+
+	executor := &countingExecutor{}
+	chunks, selected := 0, 0
+	run := func(rows []map[string]any, pks []any) execSummary {
+		require.LessOrEqual(t, len(rows), 64)
+		chunks, selected = chunks+1, selected+len(rows)
+		commands, err := render("task {{ .id }}", rows)
+		require.Nil(t, err)
+		return execute(context.Background(), db, pks, commands, execOptions{parallelism: 8, executor: executor})
+	}
+	summary, err := executeChunks(context.Background(), db, LiteArgsDbFilter{}, 64, run)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 900, failed: 100}, summary)
+	require.Equal(t, int32(1000), executor.runs.Load())
+	require.Equal(t, 16, chunks)
+	require.Equal(t, 1000, selected)
+
+	chunks, selected = 0, 0
+	summary, err = executeChunks(context.Background(), db, LiteArgsDbFilter{Take: 70}, 64, run)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{failed: 70}, summary)
+	require.Equal(t, 2, chunks)
+	require.Equal(t, 70, selected)
+}
+
+func TestExecuteSkipTemplateErrors(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"N-1"}, []string{"X"}, []string{"N-3"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+
+	_, err = render("echo {{ slice .name 0 3 | lower }}", rows)
+	require.NotNil(t, err)
+
+	rows, pks, commands, err := renderSkipping("echo {{ slice .name 0 3 | lower }}", rows, pks)
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(1), int64(3)}, pks)
+	require.Len(t, rows, 2)
+	require.Equal(t, []string{"echo n-1", "echo n-3"}, commands)
+
+	executor := &fakeExecutor{}
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, executor: executor})
+	require.Equal(t, execSummary{failed: 2}, summary)
+	require.Equal(t, []string{"echo n-1", "echo n-3"}, executor.commands)
+
+	_, _, _, err = renderSkipping("echo {{ lower .name ", rows, pks)
+	require.NotNil(t, err)
+}
+
+func TestExecuteBatches(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"url"}, []string{"a"}, []string{"b"}, []string{"c"}, []string{"d"}, []string{"e"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, batches, heads, err := renderBatches("check{{ range .rows }} {{ .url }}{{ end }} ok", rows, pks, 2)
+	require.Nil(t, err)
+	require.Equal(t, []string{"check a b ok", "check c d ok", "check e ok"}, commands)
+	require.Equal(t, [][]any{{int64(1), int64(2)}, {int64(3), int64(4)}, {int64(5)}}, batches)
+	require.Equal(t, []map[string]any{rows[0], rows[2], rows[4]}, heads)
+
+	executor := &fakeExecutor{}
+	var results bytes.Buffer
+	summary := execute(context.Background(), db, []any{int64(1), int64(3), int64(5)}, commands, execOptions{parallelism: 1, executor: executor, batches: batches, results: &results})
+	require.Equal(t, execSummary{succeed: 5}, summary)
+	require.Equal(t, commands, executor.commands)
+	var emitted []int64
+	for _, line := range strings.Split(strings.TrimSpace(results.String()), "\n") {
+		var result struct {
+			RowId int64 `json:"rowid"`
+		}
+		require.Nil(t, json.Unmarshal([]byte(line), &result))
+		emitted = append(emitted, result.RowId)
+	}
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, emitted)
+	for _, pk := range pks {
+		_, row, err := db.Get(pk)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), row["succeed"])
+		require.Equal(t, int64(1), row["attempts"])
+	}
+	_, row, err := db.Get(int64(4))
+	require.Nil(t, err)
+	require.Equal(t, "check c d ok", row["last_command"])
+}
+
+func TestExecuteRunId(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"0"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	first := newUUID()
+	require.Equal(t, execSummary{succeed: 1, failed: 1}, execute(context.Background(), db, pks[:2], commands[:2], execOptions{parallelism: 2, shell: "sh", runId: first}))
+	require.Equal(t, execSummary{succeed: 1}, execute(context.Background(), db, pks[2:], commands[2:], execOptions{parallelism: 2, shell: "sh", runId: "run-2"}))
+
+	runIds := make([]any, 0, len(pks))
+	for _, pk := range pks {
+		_, row, err := db.Get(pk)
+		require.Nil(t, err)
+		runIds = append(runIds, row["last_run_id"])
+	}
+	require.Equal(t, []any{first, first, "run-2"}, runIds)
+
+	require.Nil(t, db.Reset("", "", false))
+	_, row, err := db.Get(pks[0])
+	require.Nil(t, err)
+	require.Nil(t, row["last_run_id"])
+}
+
+func TestExecuteTimeoutColumn(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name", "timeout"}, []string{"fast", "5s"}, []string{"slow", "100ms"}, []string{"default", ""})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	timeouts, err := rowTimeouts(rows, "timeout", 200*time.Millisecond)
+	require.Nil(t, err)
+	require.Equal(t, []time.Duration{5 * time.Second, 100 * time.Millisecond, 200 * time.Millisecond}, timeouts)
+
+	commands := []string{"sleep 0.5", "exec sleep 5", "exec sleep 5"}
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 3, shell: "sh", timeouts: timeouts})
+	require.Equal(t, execSummary{succeed: 1, failed: 2}, summary)
+
+	states := make([]any, 0, len(pks))
+	for _, pk := range pks {
+		_, row, err := db.Get(pk)
+		require.Nil(t, err)
+		states = append(states, row["last_state"])
+	}
+	require.Equal(t, []any{"ok", "timeout", "timeout"}, states)
+
+	_, err = rowTimeouts([]map[string]any{{"rowid": int64(1), "timeout": "soon"}}, "timeout", 0)
+	require.ErrorContains(t, err, "invalid timeout 'soon'")
+}
+
+func TestExecuteProgressJson(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"0"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("sleep 0.2; exit {{ .code }}", rows)
+	require.Nil(t, err)
+	var progress bytes.Buffer
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 2, shell: "sh", progress: &progress, progressInterval: 50 * time.Millisecond})
+	require.Equal(t, execSummary{succeed: 2, failed: 1}, summary)
+
+	events := make([]progressEvent, 0)
+	decoder := json.NewDecoder(&progress)
+	for decoder.More() {
+		var event progressEvent
+		require.Nil(t, decoder.Decode(&event))
+		require.Equal(t, 3, event.Total)
+		events = append(events, event)
+	}
+	require.Greater(t, len(events), 1)
+	require.Greater(t, events[0].Running, int32(0))
+	last := events[len(events)-1]
+	require.Equal(t, progressEvent{Completed: 3, Total: 3, Succeeded: 2, Failed: 1, ElapsedMs: last.ElapsedMs}, last)
+	require.GreaterOrEqual(t, last.ElapsedMs, int64(400))
+}
+
+func TestExecuteDedupCommands(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"host"}, []string{"a"}, []string{"b"}, []string{"a"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	logFile := filepath.Join(t.TempDir(), "log")
+	commands, err := render("echo {{ .host }} >> "+logFile+"; echo {{ .host }}", rows)
+	require.Nil(t, err)
+	distinct, batches, heads := dedupCommands(commands, rows, pks)
+	require.Equal(t, [][]any{{int64(1), int64(3)}, {int64(2)}}, batches)
+	require.Equal(t, []map[string]any{rows[0], rows[1]}, heads)
+
+	summary := execute(context.Background(), db, []any{int64(1), int64(2)}, distinct, execOptions{parallelism: 2, shell: "sh", batches: batches})
+	require.Equal(t, execSummary{succeed: 3}, summary)
+	executed, err := os.ReadFile(logFile)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"a", "b"}, strings.Fields(string(executed)))
+	for i, pk := range pks {
+		_, row, err := db.Get(pk)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), row["succeed"])
+		require.Equal(t, rows[i]["host"].(string)+"\n", row["last_stdout"])
+	}
+}
+
+func TestRetryFile(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"0"}, []string{"2"}, []string{"3"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{failed: 1}, execute(context.Background(), db, pks[4:], commands[4:], execOptions{parallelism: 1, shell: "sh", runId: "previous"}))
+	require.Equal(t, execSummary{succeed: 2, failed: 2}, execute(context.Background(), db, pks[:4], commands[:4], execOptions{parallelism: 2, shell: "sh", runId: "current"}))
+
+	path := filepath.Join(t.TempDir(), "retry.txt")
+	failed, err := writeRetryFile(path, db, "current", false)
+	require.Nil(t, err)
+	require.Equal(t, 2, failed)
+	content, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "2\n4\n", string(content))
+
+	failed, err = writeRetryFile(path, db, "current", true)
+	require.Nil(t, err)
+	require.Equal(t, 2, failed)
+	content, err = os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "# exit 1\n2\n# exit 2\n4\n", string(content))
+
+	rowIds, err := readRowIds(bytes.NewReader(content))
+	require.Nil(t, err)
+	require.Equal(t, []int64{2, 4}, rowIds)
+	_, retryPks, err := db.Filter(LiteArgsDbFilter{RowIds: rowIds, PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2), int64(4)}, retryPks)
+
+	_, err = readRowIds(strings.NewReader("1\n\nabc\n"))
+	require.ErrorContains(t, err, "'abc' at line 3")
+}
+
+func TestExecuteCaptureJson(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"host", "ip"}, []string{"a", ""}, []string{"b", ""}, []string{"c", ""})
+	_, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands := []string{
+		`echo '{"ip":"1.2.3.4","port":80,"meta":{"dc":"eu"}}'`,
+		`echo '{"ip":"5.6.7.8","succeed":0,"bad key":1}'`,
+		`echo not json`,
+	}
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", captureJson: true})
+	require.Equal(t, execSummary{succeed: 3}, summary)
+	rows, _, err := db.Filter(LiteArgsDbFilter{WhereRaw: "1 = 1", PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "host": "a", "ip": "1.2.3.4"},
+		{"rowid": int64(2), "host": "b", "ip": "5.6.7.8"},
+		{"rowid": int64(3), "host": "c", "ip": ""},
+	}, rows)
+
+	require.Nil(t, db.Reset("", "", false))
+	summary = execute(context.Background(), db, pks[:1], commands[:1], execOptions{parallelism: 1, shell: "sh", captureJson: true, captureJsonGrow: true})
+	require.Equal(t, execSummary{succeed: 1}, summary)
+	require.Equal(t, []string{"rowid", "host", "ip", "meta", "port"}, db.Columns())
+	_, row, err := db.Get(pks[0])
+	require.Nil(t, err)
+	require.Equal(t, int64(80), row["port"])
+	require.Equal(t, `{"dc":"eu"}`, row["meta"])
+
+	_, err = db.AddColumns([]string{"ok", "succeed"})
+	require.ErrorContains(t, err, "invalid column name 'succeed'")
+	_, err = db.AddColumns([]string{"bad key"})
+	require.ErrorContains(t, err, "invalid column name 'bad key'")
+}
+
+func TestExecuteClaimedWorkers(t *testing.T) {
+	captureLogs(t)
+	file := filepath.Join(t.TempDir(), "state.db")
+	db, err := NewLiteArgsDb(file)
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	for i := 1; i <= 20; i++ {
+		require.Nil(t, db.Insert([]string{fmt.Sprintf("n-%v", i)}))
+	}
+	logFile := filepath.Join(t.TempDir(), "log")
+	runRows := func(workerDb *LiteArgsDb, template string) func(rows []map[string]any, pks []any) (execSummary, error) {
+		return func(rows []map[string]any, pks []any) (execSummary, error) {
+			commands, err := render(template, rows)
+			if err != nil {
+				return execSummary{}, err
+			}
+			return execute(context.Background(), workerDb, pks, commands, execOptions{parallelism: 4, shell: "sh"}), nil
+		}
+	}
+	executed := func() map[string]int {
+		content, err := os.ReadFile(logFile)
+		require.Nil(t, err)
+		counts := make(map[string]int)
+		for _, name := range strings.Fields(string(content)) {
+			counts[name]++
+		}
+		return counts
+	}
+	// both workers select all rows before any of them starts, so the late one sees rows already finished by the other
+	selectedRows, selectedPks := make([][]map[string]any, 2), make([][]any, 2)
+	workers := make([]*LiteArgsDb, 2)
+	for i := range workers {
+		workers[i], err = NewLiteArgsDb(file)
+		require.Nil(t, err)
+		selectedRows[i], selectedPks[i], err = workers[i].Filter(LiteArgsDbFilter{PreserveOrder: true})
+		require.Nil(t, err)
+	}
+	var group errgroup.Group
+	for i, workerDb := range workers {
+		group.Go(func() error {
+			rows, pks := selectedRows[i], selectedPks[i]
+			_, err := executeClaimed(workerDb, fmt.Sprintf("worker-%v", i), rows, pks, runRows(workerDb, `{{ if ne .name "n-5" }}echo {{ .name }} >> `+logFile+`{{ end }}`))
+			return err
+		})
+	}
+	require.Nil(t, group.Wait())
+	counts := executed()
+	require.Len(t, counts, 19)
+	for name, count := range counts {
+		require.Equal(t, 1, count, "%v executed more than once", name)
+	}
+
+	// n-5 rendered empty command and was skipped: its claim must be released, so the other worker can pick it up
+	_, row, err := db.Get(int64(5))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), row["running"])
+	require.Equal(t, "", row["worker"])
+	rows, pks, err := workers[1].Filter(LiteArgsDbFilter{WhereRaw: "1 = 1", PreserveOrder: true})
+	require.Nil(t, err)
+	summary, err := executeClaimed(workers[1], "worker-1", rows, pks, runRows(workers[1], "echo {{ .name }} >> "+logFile))
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 1}, summary)
+	require.Equal(t, 1, executed()["n-5"])
+
+	// claims are released when the run fails before the execution
+	require.Nil(t, db.Reset("", "", false))
+	rows, pks, err = db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	_, err = executeClaimed(db, "worker-0", rows, pks, runRows(db, "{{ slice .name 0 30 }}"))
+	require.NotNil(t, err)
+	_, running, err := db.Filter(LiteArgsDbFilter{WhereRaw: "running = 1"})
+	require.Nil(t, err)
+	require.Empty(t, running)
+}
+
+func TestExecuteLockOnSuccess(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 1}, execute(context.Background(), db, pks[:1], commands[:1], execOptions{parallelism: 1, shell: "sh", lockOnSuccess: true}))
+	require.Equal(t, execSummary{succeed: 1, failed: 1}, execute(context.Background(), db, pks[1:], []string{"echo n-2", "exit 1"}, execOptions{parallelism: 1, shell: "sh"}))
+
+	rerun := LiteArgsDbFilter{WhereRaw: "succeed = 1", PreserveOrder: true}
+	_, rerunPks, err := db.Filter(rerun)
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, rerunPks)
+	rerun.IncludeLocked = true
+	_, rerunPks, err = db.Filter(rerun)
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(1), int64(2)}, rerunPks)
+
+	require.Nil(t, db.Reset("", "", false))
+	_, row, err := db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, int64(1), row["succeed"])
+	require.Equal(t, int64(1), row["locked"])
+	_, pending, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2), int64(3)}, pending)
+
+	// rows executed by primary key bypass selection filters, so the locked row state is protected on write
+	require.Equal(t, execSummary{skipped: 1}, execute(context.Background(), db, pks[:1], []string{"exit 1"}, execOptions{parallelism: 1, shell: "sh", maxAttempts: 3}))
+	_, row, err = db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, int64(1), row["succeed"])
+	require.Equal(t, int64(1), row["attempts"])
+	require.ErrorIs(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: false}), ErrRowLocked)
+	require.Equal(t, execSummary{failed: 1}, execute(context.Background(), db, pks[:1], []string{"exit 1"}, execOptions{parallelism: 1, shell: "sh", force: true}))
+	_, row, err = db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), row["succeed"])
+	require.Equal(t, int64(2), row["attempts"])
+
+	require.Nil(t, db.Reset("", "", true))
+	_, row, err = db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), row["succeed"])
+	require.Equal(t, int64(0), row["locked"])
+}
+
+func TestFailingCommands(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"2"}, []string{"3"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 1, failed: 3}, execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", runId: "run-1"}))
+
+	_, row, err := db.Get(pks[0])
+	require.Nil(t, err)
+	require.Equal(t, "exit 0", row["last_command"])
+
+	var buffer bytes.Buffer
+	require.Nil(t, failingCommands(&buffer, db, "run-1", 2))
+	require.Contains(t, buffer.String(), "first 2 of 3 failed commands:\n# rowid=2, attempts=1\nexit 1\n# rowid=3, attempts=1\nexit 2\n")
+	require.NotContains(t, buffer.String(), "exit 3")
+
+	buffer.Reset()
+	require.Nil(t, failingCommands(&buffer, db, "run-2", 2))
+	require.Empty(t, buffer.String())
+
+	// failures of concurrent runs which are recorded by other run ids are not reported
+	require.Equal(t, execSummary{failed: 1}, execute(context.Background(), db, pks[3:], commands[3:], execOptions{parallelism: 1, shell: "sh", runId: "run-2"}))
+	buffer.Reset()
+	require.Nil(t, failingCommands(&buffer, db, "run-2", 2))
+	require.Contains(t, buffer.String(), "first 1 of 1 failed commands:\n# rowid=4, attempts=2\nexit 3\n")
+}
+
+func TestExecuteRecordEnv(t *testing.T) {
+	captureLogs(t)
+	recorded, err := recordEnv([]string{"REGION", "TOKEN", "MISSING"}, []string{"REGION=eu", "TOKEN=a=b", "OTHER=1", "REGION=us"})
+	require.Nil(t, err)
+	require.JSONEq(t, `{"REGION": "us", "TOKEN": "a=b", "MISSING": null}`, recorded)
+
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	summary := execute(context.Background(), db, pks[:1], commands[:1], execOptions{parallelism: 1, shell: "sh", recordedEnv: recorded})
+	require.Equal(t, execSummary{succeed: 1}, summary)
+	summary = execute(context.Background(), db, pks[1:], commands[1:], execOptions{parallelism: 1, shell: "sh"})
+	require.Equal(t, execSummary{succeed: 1}, summary)
+
+	_, row, err := db.Get(pks[0])
+	require.Nil(t, err)
+	require.JSONEq(t, recorded, row["last_env"].(string))
+	_, row, err = db.Get(pks[1])
+	require.Nil(t, err)
+	require.Nil(t, row["last_env"])
+}
+
+func TestExecuteOnlyNew(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	watermark, err := execWatermark(db)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), watermark)
+
+	run := func(take int) []string {
+		watermark, err := execWatermark(db)
+		require.Nil(t, err)
+		rows, pks, err := db.Filter(LiteArgsDbFilter{AfterRowId: watermark, Take: take, PreserveOrder: true})
+		require.Nil(t, err)
+		commands, err := render("run {{ .name }}", rows)
+		require.Nil(t, err)
+		executor := &fakeExecutor{}
+		execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, executor: executor})
+		require.Nil(t, advanceExecWatermark(db, maxRowId(pks)))
+		return executor.commands
+	}
+	require.Equal(t, []string{"run n-1", "run n-2"}, run(0))
+	require.Nil(t, db.Insert([]string{"n-3"}))
+	require.Nil(t, db.Insert([]string{"n-4"}))
+	require.Nil(t, db.Insert([]string{"n-5"}))
+	// rows which weren't taken by the limited run must be selected by the next one
+	require.Equal(t, []string{"run n-3"}, run(1))
+	require.Equal(t, []string{"run n-4", "run n-5"}, run(0))
+	require.Nil(t, run(0))
+
+	watermark, err = execWatermark(db)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), watermark)
+
+	require.Nil(t, advanceExecWatermark(db, 2))
+	watermark, err = execWatermark(db)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), watermark)
+}
+
+func TestExecuteCheckpointEvery(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"}, []string{"n-4"}, []string{"n-5"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 2, shell: "sh", checkpointEvery: 2})
+	require.Equal(t, execSummary{succeed: 5}, summary)
+
+	require.Nil(t, db.Reset("", "", false))
+	_, err = db.db.Exec("PRAGMA journal_mode = WAL")
+	require.Nil(t, err)
+	summary = execute(context.Background(), db, pks, commands, execOptions{parallelism: 2, shell: "sh", checkpointEvery: 2})
+	require.Equal(t, execSummary{succeed: 5, checkpoints: 2}, summary)
+}
+
+func TestSuccessCriteria(t *testing.T) {
+	exitCodes := successCriteria{exitCodes: []int{0, 2}}
+	require.True(t, exitCodes.check(CommandResult{Succeed: true, ExitCode: 0}))
+	require.False(t, exitCodes.check(CommandResult{ExitCode: 1}))
+	require.True(t, exitCodes.check(CommandResult{ExitCode: 2}))
+	require.False(t, exitCodes.check(CommandResult{ExitCode: -1}))
+
+	stdoutRegex := successCriteria{stdoutRegex: regexp.MustCompile(`(?m)^DONE$`)}
+	require.True(t, stdoutRegex.check(CommandResult{Succeed: true, Stdout: "step\nDONE\n"}))
+	require.False(t, stdoutRegex.check(CommandResult{Succeed: true, Stdout: "error: not DONE\n"}))
+	require.False(t, stdoutRegex.check(CommandResult{ExitCode: 1, Stdout: "DONE\n"}))
+
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", success: &exitCodes})
+	require.Equal(t, execSummary{succeed: 2, failed: 1}, summary)
+}
+
+func TestExecuteFailOnStderr(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}; {{ if eq .name \"n-2\" }}echo warning >&2{{ end }}", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", success: &successCriteria{failOnStderr: true}})
+	require.Equal(t, execSummary{succeed: 1, failed: 1}, summary)
+	_, row, err := db.Get(pks[1])
+	require.Nil(t, err)
+	require.Equal(t, int64(0), row["succeed"])
+	require.Equal(t, int64(0), row["exit_code"])
+	require.Equal(t, "warning\n", row["last_stderr"])
+}
+
+func TestExecuteTrimOutput(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}; echo; echo err >&2", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", trimOutput: true})
+	require.Equal(t, execSummary{succeed: 1}, summary)
+	var stdout, stderr string
+	require.Nil(t, db.db.QueryRow(`SELECT last_stdout, last_stderr FROM liteargs WHERE rowid = 1`).Scan(&stdout, &stderr))
+	require.Equal(t, "n-1", stdout)
+	require.Equal(t, "err", stderr)
+}
+
+func TestExecuteTemplatedShell(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"shell"}, []string{"sh"}, []string{"bash"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	shells, err := renderShells("{{ .shell }}", rows, true)
+	require.Nil(t, err)
+	require.Equal(t, []string{"sh", "bash"}, shells)
+	commands, err := render("echo $0", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "{{ .shell }}", shells: shells})
+	require.Equal(t, execSummary{succeed: 2}, summary)
+	for i, expected := range []string{"sh\n", "bash\n"} {
+		_, row, err := db.Get(pks[i])
+		require.Nil(t, err)
+		require.Equal(t, expected, row["last_stdout"])
+	}
+
+	shells, err = renderShells("sh", rows, true)
+	require.Nil(t, err)
+	require.Nil(t, shells)
+	_, err = renderShells("{{ .shell }}-missing", rows, true)
+	require.ErrorContains(t, err, "rendered shell 'sh-missing' isn't executable")
+	_, err = renderShells("{{ .shell }}-missing", rows, false)
+	require.Nil(t, err)
+}
+
+func TestExecuteOutputTemplate(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"name", "code"}, []string{"n-1", "3"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}; exit {{ .code }}", rows)
+	require.Nil(t, err)
+	outputTemplate, err := parseTemplate("{{ .rowid }} {{ .name }}: {{ .exit_code }} {{ .succeed }} {{ trim .stdout }}")
+	require.Nil(t, err)
+
+	var output bytes.Buffer
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", outputTemplate: outputTemplate, output: &output, rows: rows})
+	require.Equal(t, execSummary{failed: 1}, summary)
+	require.Equal(t, "1 n-1: 3 false n-1\n", output.String())
+}
+
+func TestExecuteMaxAttempts(t *testing.T) {
+	captureLogs(t)
+	dir := t.TempDir()
+	db := testDb(t, []string{"name"}, []string{"flaky"}, []string{"broken"}, []string{"stable"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render(fmt.Sprintf(`test "{{ .name }}" = stable || {{ if eq .name "flaky" }}test -f %[1]v/marker || (touch %[1]v/marker; exit 1){{ else }}exit 2{{ end }}`, dir), rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", maxAttempts: 3, retryBackoff: time.Millisecond})
+	require.Equal(t, execSummary{succeed: 2, failed: 1, retried: 3}, summary)
+	for i, expected := range []struct {
+		succeed  int64
+		attempts int64
+	}{{succeed: 1, attempts: 2}, {succeed: 0, attempts: 3}, {succeed: 1, attempts: 1}} {
+		_, row, err := db.Get(pks[i])
+		require.Nil(t, err)
+		require.Equal(t, expected.succeed, row["succeed"])
+		require.Equal(t, expected.attempts, row["attempts"])
+	}
+}
+
+func TestExecuteRetryExitCodes(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"75"}, []string{"2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true, RetryExitCodes: []int{1, 75}})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", maxAttempts: 3, retryExitCodes: []int{1, 75}})
+	require.Equal(t, execSummary{failed: 2, retried: 2}, summary)
+	for i, attempts := range []int64{3, 1} {
+		_, row, err := db.Get(pks[i])
+		require.Nil(t, err)
+		require.Equal(t, attempts, row["attempts"])
+	}
+
+	rows, _, err = db.Filter(LiteArgsDbFilter{RetryExitCodes: []int{1, 75}})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "code": "75"}}, rows)
+}
+
+func TestWriteSummary(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("echo out; exit {{ .code }}", rows)
+	require.Nil(t, err)
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", collectResults: true})
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	require.Nil(t, writeSummary(path, summary, 1500*time.Millisecond))
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	var written map[string]any
+	require.Nil(t, json.Unmarshal(data, &written))
+	require.Equal(t, float64(1), written["succeed"])
+	require.Equal(t, float64(1), written["failed"])
+	require.Equal(t, float64(0), written["db_errors"])
+	require.Equal(t, float64(1500), written["elapsed_ms"])
+	results := written["results"].([]any)
+	require.Len(t, results, 2)
+	require.Equal(t, float64(1), results[1].(map[string]any)["exit_code"])
+	require.Equal(t, "out\n", results[1].(map[string]any)["stdout"])
+
+	summary.results = nil
+	require.Nil(t, writeSummary(path, summary, time.Second))
+	data, err = os.ReadFile(path)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "results")
+}
+
+func TestExecuteLastState(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"command"}, []string{"true"}, []string{"false"}, []string{"exec sleep 5"}, []string{"exec sleep 5"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("{{ .command }}", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks[:2], commands[:2], execOptions{parallelism: 1, shell: "sh"})
+	require.Equal(t, execSummary{succeed: 1, failed: 1}, summary)
+	timeoutCtx, cancelTimeout := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelTimeout()
+	execute(timeoutCtx, db, pks[2:3], commands[2:3], execOptions{parallelism: 1, shell: "sh"})
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	execute(cancelCtx, db, pks[3:], commands[3:], execOptions{parallelism: 1, shell: "sh"})
+
+	for i, state := range []string{"ok", "failed", "timeout", "interrupted"} {
+		_, row, err := db.Get(pks[i])
+		require.Nil(t, err)
+		require.Equal(t, state, row["last_state"])
+	}
+}
+
+func TestExecuteGroupParallelism(t *testing.T) {
+	captureLogs(t)
+	dir := t.TempDir()
+	db := testDb(t, []string{"host"}, []string{"a"}, []string{"a"}, []string{"a"}, []string{"b"}, []string{"b"}, []string{"b"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render(fmt.Sprintf("mkdir %[1]v/{{ .host }} && sleep 0.1 && rmdir %[1]v/{{ .host }}", dir), rows)
+	require.Nil(t, err)
+	groups := []string{"a", "a", "a", "b", "b", "b"}
+
+	startTime := time.Now()
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 4, shell: "sh", groups: groups, groupParallelism: 1})
+	require.Equal(t, execSummary{succeed: 6}, summary)
+	require.Less(t, time.Since(startTime), 550*time.Millisecond)
+
+	require.Nil(t, db.Reset("", "", false))
+	summary = execute(context.Background(), db, pks, commands, execOptions{parallelism: 4, shell: "sh"})
+	require.Greater(t, summary.failed, int32(0))
+
+	// commands of the busy group must not hold global slots needed by the other group
+	require.Nil(t, db.Reset("", "", false))
+	commands = []string{
+		fmt.Sprintf("sleep 0.2 && touch %v/done", dir),
+		fmt.Sprintf("sleep 0.2 && touch %v/done", dir),
+		fmt.Sprintf("sleep 0.2 && touch %v/done", dir),
+		fmt.Sprintf("sleep 0.2 && touch %v/done", dir),
+		fmt.Sprintf("test ! -e %v/done", dir),
+		fmt.Sprintf("test ! -e %v/done", dir),
+	}
+	groups = []string{"a", "a", "a", "a", "b", "b"}
+	summary = execute(context.Background(), db, pks, commands, execOptions{parallelism: 2, shell: "sh", groups: groups, groupParallelism: 1})
+	require.Equal(t, execSummary{succeed: 6}, summary)
+}
+
+func TestExecuteCaptureNumber(t *testing.T) {
+	logs := captureLogs(t)
+	db := testDb(t, []string{"output"}, []string{"40"}, []string{"1.5"}, []string{"  2 "}, []string{"n/a"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("echo '{{ .output }}'", rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", captureNumber: true})
+	require.Equal(t, execSummary{succeed: 4}, summary)
+	var sum float64
+	require.Nil(t, db.db.QueryRow(`SELECT SUM(result_value) FROM liteargs`).Scan(&sum))
+	require.Equal(t, 43.5, sum)
+	_, row, err := db.Get(pks[3])
+	require.Nil(t, err)
+	require.Nil(t, row["result_value"])
+	require.Equal(t, "output isn't a number: 'n/a'", row["result_value_error"])
+	require.Contains(t, logs.String(), "failed to capture number: rowid=4")
+}
+
+func TestExecuteEmptyCommand(t *testing.T) {
+	logs := captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{""})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("{{ if .name }}echo {{ .name }}{{ end }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, emptyCommands(pks, commands))
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"})
+	require.Equal(t, execSummary{succeed: 1, skipped: 1}, summary)
+	require.Contains(t, logs.String(), "skipped empty command: rowid=2")
+
+	rows, _, err = db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(2), "name": ""}}, rows)
+}
+
+func TestExecuteMaxOutputLines(t *testing.T) {
+	require.Equal(t, "", lastLines("", 2))
+	require.Equal(t, "a\nb\n", lastLines("a\nb\n", 2))
+	require.Equal(t, "[truncated 1 lines]\nb\nc", lastLines("a\nb\nc", 2))
+
+	captureLogs(t)
+	db := testDb(t, []string{"n"}, []string{"100"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("seq {{ .n }}", rows)
+	require.Nil(t, err)
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh", maxOutputLines: 3})
+	require.Equal(t, execSummary{succeed: 1}, summary)
+	var stdout string
+	require.Nil(t, db.db.QueryRow(`SELECT last_stdout FROM liteargs WHERE rowid = 1`).Scan(&stdout))
+	require.Equal(t, "[truncated 97 lines]\n98\n99\n100\n", stdout)
+}
+
+func TestExecuteHooks(t *testing.T) {
+	captureLogs(t)
+	trace := filepath.Join(t.TempDir(), "trace")
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render(fmt.Sprintf("echo {{ .name }} >> %v; exit 1", trace), rows)
+	require.Nil(t, err)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{
+		parallelism: 1,
+		shell:       "sh",
+		preExec:     fmt.Sprintf("echo pre >> %v", trace),
+		postExec:    fmt.Sprintf("echo post >> %v", trace),
+	})
+	require.Equal(t, execSummary{failed: 2}, summary)
+	content, err := os.ReadFile(trace)
+	require.Nil(t, err)
+	require.Equal(t, "pre\nn-1\nn-2\npost\n", string(content))
+
+	require.Nil(t, os.Remove(trace))
+	summary = execute(context.Background(), db, pks, commands, execOptions{
+		parallelism: 1,
+		shell:       "sh",
+		preExec:     "exit 1",
+		postExec:    fmt.Sprintf("echo post >> %v", trace),
+	})
+	require.Equal(t, execSummary{hookErrors: 1}, summary)
+	content, err = os.ReadFile(trace)
+	require.Nil(t, err)
+	require.Equal(t, "post\n", string(content))
+}
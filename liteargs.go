@@ -1,178 +1,1368 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNoTable is returned when the liteargs table wasn't initialized yet
+	ErrNoTable = errors.New("liteargs table doesn't exist")
+	// ErrRowNotFound is returned when the row with requested rowid doesn't exist
+	ErrRowNotFound = errors.New("liteargs row not found")
+	// ErrLocked is returned when the database is locked by another connection for longer than busy timeout
+	ErrLocked = errors.New("liteargs database is locked")
+	// ErrRowLocked is returned when the result is recorded for the row locked after the successful attempt without force
+	ErrRowLocked = errors.New("liteargs row is locked")
 )
 
+var missingTableRegex = regexp.MustCompile(`no such table: (main\.)?liteargs\b`)
+
+// sqlError wraps error returned by the database with the matching sentinel error, so callers can check it with errors.Is
+func sqlError(err error) error {
+	if err == nil || errors.Is(err, ErrNoTable) || errors.Is(err, ErrRowNotFound) || errors.Is(err, ErrLocked) || errors.Is(err, ErrRowLocked) {
+		return err
+	}
+	message := err.Error()
+	switch {
+	case missingTableRegex.MatchString(message):
+		return fmt.Errorf("%w: %w", ErrNoTable, err)
+	case strings.Contains(message, "database is locked"), strings.Contains(message, "database table is locked"), strings.Contains(message, "database is busy"):
+		return fmt.Errorf("%w: %w", ErrLocked, err)
+	}
+	return err
+}
+
+type stateColumn struct {
+	name       string
+	definition string
+}
+
+// stateColumns are bookkeeping columns maintained by liteargs next to the user provided arguments
+var stateColumns = []stateColumn{
+	{name: "succeed", definition: "INT DEFAULT 0"},
+	{name: "attempts", definition: "INT DEFAULT 0"},
+	{name: "last_stdout", definition: `TEXT DEFAULT ""`},
+	{name: "last_stderr", definition: `TEXT DEFAULT ""`},
+	{name: "last_attempt_dt", definition: `TEXT DEFAULT ""`},
+	{name: "running", definition: "INT DEFAULT 0"},
+	{name: "worker", definition: `TEXT DEFAULT ""`},
+	{name: "exit_code", definition: "INT DEFAULT NULL"},
+	{name: "liteargs_tag", definition: "TEXT DEFAULT NULL"},
+	{name: "last_duration_ms", definition: "INT DEFAULT NULL"},
+	{name: "loaded_at", definition: "TEXT DEFAULT NULL"},
+	{name: "last_state", definition: "TEXT DEFAULT NULL"},
+	{name: "result_value", definition: "NUMERIC DEFAULT NULL"},
+	{name: "result_value_error", definition: "TEXT DEFAULT NULL"},
+	{name: "last_env", definition: "TEXT DEFAULT NULL"},
+	{name: "last_command", definition: "TEXT DEFAULT NULL"},
+	{name: "locked", definition: "INT DEFAULT 0"},
+	{name: "last_run_id", definition: "TEXT DEFAULT NULL"},
+}
+
+// enabledColumn is an optional user column which disables rows with 0 or false value
+const enabledColumn = "enabled"
+
+// loadedAtLayout keeps sub-second precision so loaded_at is ordered even for rows loaded within the same second
+const loadedAtLayout = "2006-01-02 15:04:05.000000"
+
+func isStateColumn(name string) bool {
+	for _, column := range stateColumns {
+		if column.name == name {
+			return true
+		}
+	}
+	return false
+}
+
 type LiteArgsDb struct {
 	lock         *sync.Mutex
 	db           *sql.DB
+	header       []string
 	columns      string
 	placeholders string
 }
 
+// extensions are paths of SQLite extensions loaded into every connection of the state db opened by NewLiteArgsDb
+var extensions []string
+
+// extensionsConnector opens SQLite connections with loaded extensions which libsql driver can't configure
+type extensionsConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c extensionsConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c extensionsConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// authToken is used to access the remote libsql state db opened by NewLiteArgsDb
+var authToken string
+
+// isRemoteDb checks if the state db is the URL of the remote libsql server rather than the local file
+func isRemoteDb(file string) bool {
+	for _, scheme := range []string{"libsql://", "https://", "http://", "wss://", "ws://"} {
+		if strings.HasPrefix(file, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// stateDbDsn returns DSN of the state db for the libsql driver; local files aren't created if create is false
+func stateDbDsn(file string, create bool) (string, error) {
+	if isRemoteDb(file) {
+		u, err := url.Parse(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse liteargs state db url: %w", err)
+		}
+		if authToken != "" {
+			query := u.Query()
+			query.Set("authToken", authToken)
+			u.RawQuery = query.Encode()
+		}
+		return u.String(), nil
+	}
+	dsn := "file:" + strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23").Replace(file)
+	if !create {
+		dsn += "?mode=rw"
+	}
+	return dsn, nil
+}
+
 func NewLiteArgsDb(file string) (*LiteArgsDb, error) {
-	db, err := sql.Open("libsql", fmt.Sprintf("file:%v", file))
+	return openLiteArgsDb(file, true)
+}
+
+// OpenLiteArgsDb opens existing state db and fails if the local file doesn't exist
+func OpenLiteArgsDb(file string) (*LiteArgsDb, error) {
+	return openLiteArgsDb(file, false)
+}
+
+func openLiteArgsDb(file string, create bool) (*LiteArgsDb, error) {
+	dsn, err := stateDbDsn(file, create)
 	if err != nil {
+		return nil, err
+	}
+	var db *sql.DB
+	if len(extensions) > 0 {
+		if isRemoteDb(file) {
+			return nil, fmt.Errorf("failed to open liteargs state db: extensions can't be loaded into the remote state db")
+		}
+		db = sql.OpenDB(extensionsConnector{driver: &sqlite3.SQLiteDriver{Extensions: extensions}, dsn: dsn})
+	} else if db, err = sql.Open("libsql", dsn); err != nil {
 		return nil, fmt.Errorf("failed to open liteargs state db: %w", err)
 	}
 	liteArgsDb := &LiteArgsDb{lock: &sync.Mutex{}, db: db}
-	if err = liteArgsDb.init(); err != nil {
+	if err := liteArgsDb.init(); err != nil {
 		return nil, err
 	}
 	return liteArgsDb, nil
 }
 
-func (l *LiteArgsDb) init() error {
-	result, err := l.db.Query(`
-	SELECT name FROM pragma_table_info('liteargs') WHERE name NOT IN (
-		'succeed', 'attempts', 'last_stdout', 'last_stderr', 'last_attempt_dt'
-	)`)
+// tableColumns returns all columns of the liteargs table in the definition order
+func (l *LiteArgsDb) tableColumns() ([]string, error) {
+	result, err := l.db.Query(`SELECT name FROM pragma_table_info('liteargs')`)
 	if err != nil {
-		return fmt.Errorf("failed to load liteargs table info: %w", err)
+		return nil, fmt.Errorf("failed to load liteargs table info: %w", sqlError(err))
 	}
 	defer result.Close()
 
-	headers := make([]string, 0)
+	columns := make([]string, 0)
 	for result.Next() {
 		var column string
 		err = result.Scan(&column)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return fmt.Errorf("failed to load liteargs table info: %w", err)
+			return nil, fmt.Errorf("failed to load liteargs table info: %w", sqlError(err))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func (l *LiteArgsDb) init() error {
+	columns, err := l.tableColumns()
+	if err != nil {
+		return err
+	}
+	headers := make([]string, 0)
+	present := make(map[string]bool)
+	for _, column := range columns {
+		present[column] = true
+		if !isStateColumn(column) {
+			headers = append(headers, column)
 		}
-		headers = append(headers, column)
 	}
+	l.header = headers
 	l.columns = strings.Join(headers, ", ")
 	l.placeholders = strings.Join(repeat("?", len(headers)), ", ")
+	if len(present) == 0 {
+		return nil
+	}
+	return l.migrate(present)
+}
+
+// migrate adds state columns which were introduced after the state db was created
+func (l *LiteArgsDb) migrate(present map[string]bool) error {
+	for _, column := range stateColumns {
+		if present[column.name] {
+			continue
+		}
+		_, err := l.db.Exec(fmt.Sprintf("ALTER TABLE liteargs ADD COLUMN %v %v", column.name, column.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add liteargs column %v: %w", column.name, sqlError(err))
+		}
+	}
 	return nil
 }
 
+// columnDefinitions returns definitions of the user columns (with optional types) followed by the state columns
+func columnDefinitions(header []string, types map[string]string) []string {
+	definitions := make([]string, 0, len(header)+len(stateColumns))
+	for _, column := range header {
+		if columnType := types[column]; columnType != "" {
+			column = fmt.Sprintf("%v %v", column, columnType)
+		}
+		definitions = append(definitions, column)
+	}
+	for _, column := range stateColumns {
+		definitions = append(definitions, fmt.Sprintf("%v %v", column.name, column.definition))
+	}
+	return definitions
+}
+
 func (l *LiteArgsDb) Init(header []string) error {
-	createStatement := fmt.Sprintf(`
-					CREATE TABLE IF NOT EXISTS liteargs (
-    						%v, 
-    						succeed INT DEFAULT 0, 
-    						attempts INT DEFAULT 0, 
-    						last_stdout TEXT DEFAULT "",
-    						last_stderr TEXT DEFAULT "",
-    						last_attempt_dt TEXT DEFAULT ""
-					)`, strings.Join(header, ", "))
+	return l.InitTyped(header, nil)
+}
+
+// InitTyped creates the liteargs table declaring user columns with the given types (columns without type are left untyped)
+func (l *LiteArgsDb) InitTyped(header []string, types map[string]string) error {
+	createStatement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS liteargs (%v)`, strings.Join(columnDefinitions(header, types), ", "))
 	_, err := l.db.Exec(createStatement)
 	if err != nil {
-		return fmt.Errorf("failed to create liteargs table: %w", err)
+		return fmt.Errorf("failed to create liteargs table: %w", sqlError(err))
 	}
+	l.header = header
 	l.columns = strings.Join(header, ", ")
 	l.placeholders = strings.Join(repeat("?", len(header)), ", ")
 	return nil
 }
 
+// Columns returns columns of rows returned by the Filter method
+func (l *LiteArgsDb) Columns() []string {
+	return append([]string{"rowid"}, l.header...)
+}
+
 func (l *LiteArgsDb) Insert(record []string) error {
-	insertStatement := fmt.Sprintf("INSERT INTO liteargs(%v) VALUES (%v)", l.columns, l.placeholders)
-	_, err := l.db.Exec(insertStatement, anyArray(record)...)
+	return l.InsertValues(anyArray(record))
+}
+
+// InsertValues inserts row with arbitrary values (e.g. nil for NULL) in the order of table columns
+func (l *LiteArgsDb) InsertValues(values []any) error {
+	insertStatement := fmt.Sprintf("INSERT INTO liteargs(%v, loaded_at) VALUES (%v, ?)", l.columns, l.placeholders)
+	_, err := l.db.Exec(insertStatement, append(values[:len(values):len(values)], time.Now().Format(loadedAtLayout))...)
 	if err != nil {
-		return fmt.Errorf("failed to insert record: %w", err)
+		return fmt.Errorf("failed to insert record: %w", sqlError(err))
 	}
 	return nil
 }
 
-func (l *LiteArgsDb) Reset() error {
-	_, err := l.db.Exec(`UPDATE liteargs SET succeed = 0, attempts = 0, last_stdout = "", last_stderr = "", last_attempt_dt = ""`)
+// InsertBatch inserts rows tagged with non-empty tag and meta entries in a single transaction and returns index of the failed row
+func (l *LiteArgsDb) InsertBatch(rows [][]any, tag string, meta []LiteArgsDbMeta) (int, error) {
+	if len(meta) > 0 {
+		if err := l.initMeta(); err != nil {
+			return 0, err
+		}
+	}
+	tx, err := l.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert records: %w", sqlError(err))
+	}
+	columns, placeholders := l.columns+", loaded_at", l.placeholders+", ?"
+	if tag != "" {
+		columns, placeholders = columns+", liteargs_tag", placeholders+", ?"
+	}
+	loadedAt := time.Now().Format(loadedAtLayout)
+	statement, err := tx.Prepare(fmt.Sprintf("INSERT INTO liteargs(%v) VALUES (%v)", columns, placeholders))
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("failed to insert records: %w", sqlError(err))
+	}
+	defer statement.Close()
+	for i, values := range rows {
+		values = append(values[:len(values):len(values)], loadedAt)
+		if tag != "" {
+			values = append(values, tag)
+		}
+		if _, err = statement.Exec(values...); err != nil {
+			_ = tx.Rollback()
+			return i, fmt.Errorf("failed to insert record: %w", sqlError(err))
+		}
+	}
+	for _, entry := range meta {
+		_, err = tx.Exec(`INSERT INTO liteargs_meta(key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, entry.Key, entry.Value)
+		if err != nil {
+			_ = tx.Rollback()
+			return len(rows), fmt.Errorf("failed to set liteargs meta: key=%v, err=%w", entry.Key, sqlError(err))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return len(rows), fmt.Errorf("failed to commit inserted records: %w", sqlError(err))
+	}
+	return len(rows), nil
+}
+
+// resetFilter selects rows affected by the Reset method (locked rows are affected only with force)
+func resetFilter(tag, filter string, force bool) LiteArgsDbFilter {
+	return LiteArgsDbFilter{WhereRaw: cmp.Or(filter, "1 = 1"), Tag: tag, IncludeDisabled: true, IncludeLocked: force}
+}
+
+// Reset clears state of rows matching the filter (optionally only ones loaded with the tag); locked rows are cleared only with force
+func (l *LiteArgsDb) Reset(tag, filter string, force bool) error {
+	selected := resetFilter(tag, filter, force)
+	if err := selected.validate(); err != nil {
+		return err
+	}
+	where, _, _, args := l.clauses(selected)
+	_, err := l.db.Exec(`UPDATE liteargs SET succeed = 0, attempts = 0, last_stdout = "", last_stderr = "", last_attempt_dt = "", running = 0, worker = "", exit_code = NULL, last_duration_ms = NULL, last_state = NULL, result_value = NULL, result_value_error = NULL, last_env = NULL, last_command = NULL, locked = 0, last_run_id = NULL WHERE `+where, args...)
 	if err != nil {
-		return fmt.Errorf("failed to reset liteargs table state: %w", err)
+		return fmt.Errorf("failed to reset liteargs table state: %w", sqlError(err))
 	}
 	return nil
 }
 
-func (l *LiteArgsDb) attempts(tx *sql.Tx, primaryKey any) (int, error) {
-	rows, err := tx.Query(`SELECT attempts FROM liteargs WHERE rowid = ?`, primaryKey)
+// attempts returns amount of attempts of the row together with its locked flag
+func (l *LiteArgsDb) attempts(tx *sql.Tx, primaryKey any) (int, bool, error) {
+	rows, err := tx.Query(`SELECT attempts, COALESCE(locked, 0) FROM liteargs WHERE rowid = ?`, primaryKey)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get liteargs attempts: %w", err)
+		return 0, false, fmt.Errorf("failed to get liteargs attempts: %w", sqlError(err))
 	}
 	defer rows.Close()
 	if !rows.Next() {
-		return 0, fmt.Errorf("failed to find liteargs row: rowid=%v", primaryKey)
+		return 0, false, fmt.Errorf("%w: rowid=%v", ErrRowNotFound, primaryKey)
 	}
 	var attempts int
-	err = rows.Scan(&attempts)
+	var locked bool
+	err = rows.Scan(&attempts, &locked)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse attempts row: %w", err)
+		return 0, false, fmt.Errorf("failed to parse attempts row: %w", sqlError(err))
 	}
-	return attempts, nil
+	return attempts, locked, nil
+}
+
+type LiteArgsDbUpdate struct {
+	Succeed  bool
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Time     time.Time
+	Duration time.Duration
+	// State is one of ok, failed, interrupted or timeout
+	State string
+	// CaptureNumber stores ResultValue (or ResultValueError if the output isn't a number) into the result_value columns
+	CaptureNumber    bool
+	ResultValue      any
+	ResultValueError string
+	// RunId identifies exec invocation which made the attempt and is stored in the last_run_id column (NULL if empty)
+	RunId string
+	// Lock marks the row as locked if the attempt succeed, so it isn't selected or reset anymore without force
+	Lock bool
+	// Command is the rendered command of the attempt stored in the last_command column
+	Command string
+	// Env is JSON object with environment values of the attempt stored in the last_env column if it is not empty
+	Env string
+	// KeepHistory appends the attempt to the liteargs_attempts table in addition to overwriting the row state
+	KeepHistory bool
+	// Captured values are stored into the user columns with the same names; other keys are ignored
+	Captured map[string]any
+	// Force overwrites state of the row locked after the successful attempt
+	Force bool
 }
 
-func (l *LiteArgsDb) Update(primaryKey any, succeed bool, stdout, stderr string, t time.Time) error {
+func (l *LiteArgsDb) initHistory() error {
+	_, err := l.db.Exec(`
+		CREATE TABLE IF NOT EXISTS liteargs_attempts (
+			liteargs_rowid INT, 
+			attempt INT, 
+			succeed INT, 
+			exit_code INT, 
+			stdout TEXT, 
+			stderr TEXT, 
+			attempt_dt TEXT
+		)`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create liteargs attempts table: %w", sqlError(err))
+	}
+	return nil
+}
+
+// SetState overwrites only the last_state column of the row and releases it
+func (l *LiteArgsDb) SetState(primaryKey any, state string) error {
 	l.lock.Lock()
 	defer l.lock.Unlock()
+	if _, err := l.db.Exec(`UPDATE liteargs SET last_state = ?, running = 0 WHERE rowid = ?`, state, primaryKey); err != nil {
+		return fmt.Errorf("failed to set liteargs row state: %w", sqlError(err))
+	}
+	return nil
+}
+
+func (l *LiteArgsDb) Update(primaryKey any, update LiteArgsDbUpdate) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if update.KeepHistory {
+		if err := l.initHistory(); err != nil {
+			return err
+		}
+	}
 
 	tx, err := l.db.BeginTx(context.Background(), nil)
 	if err != nil {
-		return fmt.Errorf("failed to update liteargs row: %w", err)
+		return fmt.Errorf("failed to update liteargs row: %w", sqlError(err))
+	}
+	attempts, locked, err := l.attempts(tx, primaryKey)
+	if err == nil && locked && !update.Force {
+		err = fmt.Errorf("%w: rowid=%v", ErrRowLocked, primaryKey)
 	}
-	attempts, err := l.attempts(tx, primaryKey)
 	if err != nil {
 		_ = tx.Rollback()
-		return fmt.Errorf("failed to update liteargs row: %w", err)
+		return fmt.Errorf("failed to update liteargs row: %w", sqlError(err))
+	}
+	var runId any
+	if update.RunId != "" {
+		runId = update.RunId
 	}
 	_, err = tx.Exec(
-		`UPDATE liteargs SET succeed = ?, attempts = ?, last_stdout = ?, last_stderr = ?, last_attempt_dt = ? WHERE rowid = ?`,
-		succeed,
+		`UPDATE liteargs SET succeed = ?, attempts = ?, last_stdout = ?, last_stderr = ?, last_attempt_dt = ?, running = 0, exit_code = ?, last_duration_ms = ?, last_state = ?, last_command = ?, last_run_id = ? WHERE rowid = ?`,
+		update.Succeed,
 		attempts+1,
-		stdout,
-		stderr,
-		t.Format(time.DateTime),
+		update.Stdout,
+		update.Stderr,
+		update.Time.Format(time.DateTime),
+		update.ExitCode,
+		update.Duration.Milliseconds(),
+		update.State,
+		update.Command,
+		runId,
 		primaryKey,
 	)
 	if err != nil {
 		_ = tx.Rollback()
-		return fmt.Errorf("failed to update liteargs row: %w", err)
+		return fmt.Errorf("failed to update liteargs row: %w", sqlError(err))
+	}
+	if update.CaptureNumber {
+		var resultValueError any
+		if update.ResultValueError != "" {
+			resultValueError = update.ResultValueError
+		}
+		_, err = tx.Exec(`UPDATE liteargs SET result_value = ?, result_value_error = ? WHERE rowid = ?`, update.ResultValue, resultValueError, primaryKey)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to update liteargs result value: %w", sqlError(err))
+		}
+	}
+	if update.Lock && update.Succeed {
+		if _, err = tx.Exec(`UPDATE liteargs SET locked = 1 WHERE rowid = ?`, primaryKey); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to lock liteargs row: %w", sqlError(err))
+		}
+	}
+	if update.Env != "" {
+		if _, err = tx.Exec(`UPDATE liteargs SET last_env = ? WHERE rowid = ?`, update.Env, primaryKey); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to update liteargs env: %w", sqlError(err))
+		}
+	}
+	if len(update.Captured) > 0 {
+		assignments, values := make([]string, 0, len(update.Captured)), make([]any, 0, len(update.Captured)+1)
+		for _, column := range l.header {
+			if value, ok := update.Captured[column]; ok {
+				assignments, values = append(assignments, fmt.Sprintf(`"%v" = ?`, column)), append(values, value)
+			}
+		}
+		if len(assignments) > 0 {
+			_, err = tx.Exec(fmt.Sprintf(`UPDATE liteargs SET %v WHERE rowid = ?`, strings.Join(assignments, ", ")), append(values, primaryKey)...)
+			if err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("failed to update liteargs captured values: %w", sqlError(err))
+			}
+		}
+	}
+	if update.KeepHistory {
+		_, err = tx.Exec(
+			`INSERT INTO liteargs_attempts(liteargs_rowid, attempt, succeed, exit_code, stdout, stderr, attempt_dt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			primaryKey,
+			attempts+1,
+			update.Succeed,
+			update.ExitCode,
+			update.Stdout,
+			update.Stderr,
+			update.Time.Format(time.DateTime),
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to append liteargs attempt: %w", sqlError(err))
+		}
 	}
 	err = tx.Commit()
 	if err != nil {
-		return fmt.Errorf("failed to commit liteargs update: %w", err)
+		return fmt.Errorf("failed to commit liteargs update: %w", sqlError(err))
 	}
 	return nil
 }
 
+// identifierRegex matches column names which can be added to the liteargs table without quoting
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AddColumns adds missing user columns to the liteargs table and returns names of the added ones
+func (l *LiteArgsDb) AddColumns(columns []string) ([]string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.header) == 0 {
+		return nil, fmt.Errorf("failed to add liteargs columns: %w", ErrNoTable)
+	}
+	added := make([]string, 0)
+	for _, column := range columns {
+		if slices.Contains(l.header, column) || slices.Contains(added, column) {
+			continue
+		}
+		if column == "rowid" || isStateColumn(column) || !identifierRegex.MatchString(column) {
+			return added, fmt.Errorf("failed to add liteargs column: invalid column name '%v'", column)
+		}
+		if _, err := l.db.Exec(fmt.Sprintf("ALTER TABLE liteargs ADD COLUMN %v", column)); err != nil {
+			return added, fmt.Errorf("failed to add liteargs column %v: %w", column, sqlError(err))
+		}
+		added = append(added, column)
+		l.header = append(slices.Clone(l.header), column)
+		l.columns = strings.Join(l.header, ", ")
+		l.placeholders = strings.Join(repeat("?", len(l.header)), ", ")
+	}
+	return added, nil
+}
+
+const claimChunkSize = 512
+
+// Claim atomically marks pending rows as running by the worker and returns primary keys of successfully claimed rows
+func (l *LiteArgsDb) Claim(primaryKeys []any, worker string) ([]any, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	claimed := make([]any, 0, len(primaryKeys))
+	for start := 0; start < len(primaryKeys); start += claimChunkSize {
+		chunk := primaryKeys[start:min(start+claimChunkSize, len(primaryKeys))]
+		rows, err := l.db.Query(
+			fmt.Sprintf(`UPDATE liteargs SET running = 1, worker = ? WHERE rowid IN (%v) AND running = 0 AND succeed = 0 RETURNING rowid`, strings.Join(repeat("?", len(chunk)), ", ")),
+			append([]any{worker}, chunk...)...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim liteargs rows: %w", sqlError(err))
+		}
+		for rows.Next() {
+			var primaryKey int64
+			if err = rows.Scan(&primaryKey); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("failed to parse claimed rowid: %w", sqlError(err))
+			}
+			claimed = append(claimed, primaryKey)
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim liteargs rows: %w", sqlError(err))
+		}
+	}
+	return claimed, nil
+}
+
+// Release frees rows claimed by the worker which are still running and returns amount of released rows
+func (l *LiteArgsDb) Release(primaryKeys []any, worker string) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	released := 0
+	for start := 0; start < len(primaryKeys); start += claimChunkSize {
+		chunk := primaryKeys[start:min(start+claimChunkSize, len(primaryKeys))]
+		result, err := l.db.Exec(
+			fmt.Sprintf(`UPDATE liteargs SET running = 0, worker = '' WHERE rowid IN (%v) AND running = 1 AND worker = ?`, strings.Join(repeat("?", len(chunk)), ", ")),
+			append(chunk[:len(chunk):len(chunk)], worker)...,
+		)
+		if err != nil {
+			return released, fmt.Errorf("failed to release liteargs rows: %w", sqlError(err))
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return released, fmt.Errorf("failed to get amount of released rows: %w", sqlError(err))
+		}
+		released += int(affected)
+	}
+	return released, nil
+}
+
+// Missing returns requested primary keys which don't exist in the liteargs table
+func (l *LiteArgsDb) Missing(primaryKeys []int64) ([]int64, error) {
+	existing := make(map[int64]struct{}, len(primaryKeys))
+	for start := 0; start < len(primaryKeys); start += claimChunkSize {
+		chunk := primaryKeys[start:min(start+claimChunkSize, len(primaryKeys))]
+		args := make([]any, len(chunk))
+		for i, primaryKey := range chunk {
+			args[i] = primaryKey
+		}
+		rows, err := l.db.Query(fmt.Sprintf(`SELECT rowid FROM liteargs WHERE rowid IN (%v)`, strings.Join(repeat("?", len(chunk)), ", ")), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check liteargs rows: %w", sqlError(err))
+		}
+		for rows.Next() {
+			var primaryKey int64
+			if err = rows.Scan(&primaryKey); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("failed to parse rowid: %w", sqlError(err))
+			}
+			existing[primaryKey] = struct{}{}
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check liteargs rows: %w", sqlError(err))
+		}
+	}
+	missing := make([]int64, 0)
+	for _, primaryKey := range primaryKeys {
+		if _, ok := existing[primaryKey]; !ok {
+			missing = append(missing, primaryKey)
+		}
+	}
+	return missing, nil
+}
+
+// Backup writes consistent snapshot of the state db into the new file at the path
+func (l *LiteArgsDb) Backup(path string) error {
+	if _, err := l.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("failed to backup state db into %v: %w", path, sqlError(err))
+	}
+	return nil
+}
+
+func (l *LiteArgsDb) JournalMode() (string, error) {
+	var mode string
+	err := l.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode)
+	if err != nil {
+		return "", fmt.Errorf("failed to get journal mode: %w", sqlError(err))
+	}
+	return strings.ToLower(mode), nil
+}
+
+// Checkpoint moves WAL content into the database file and truncates the WAL file
+func (l *LiteArgsDb) Checkpoint() error {
+	var busy, logFrames, checkpointedFrames int
+	err := l.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointedFrames)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint wal: %w", sqlError(err))
+	}
+	if busy != 0 {
+		return fmt.Errorf("failed to checkpoint wal: %w", ErrLocked)
+	}
+	return nil
+}
+
+// Get returns all columns of the row (including state columns) in the table order
+func (l *LiteArgsDb) Get(primaryKey any) ([]string, map[string]any, error) {
+	rows, err := l.db.Query(`SELECT rowid, * FROM liteargs WHERE rowid = ?`, primaryKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get liteargs row: %w", sqlError(err))
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get results columns: %w", sqlError(err))
+	}
+	if !rows.Next() {
+		return nil, nil, fmt.Errorf("%w: rowid=%v", ErrRowNotFound, primaryKey)
+	}
+	values := make([]any, len(columns))
+	refs := make([]any, len(columns))
+	for i := range refs {
+		refs[i] = &values[i]
+	}
+	if err = rows.Scan(refs...); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse litearg row: err=%w", sqlError(err))
+	}
+	result := make(map[string]any, len(columns))
+	for i, column := range columns {
+		result[column] = values[i]
+	}
+	return columns, result, nil
+}
+
+type LiteArgsDbFailure struct {
+	PrimaryKey    int64
+	Attempts      int
+	LastStderr    string
+	LastAttemptDt string
+	LastCommand   string
+}
+
+// Failures returns failed rows which were attempted not earlier than the given timestamp
+func (l *LiteArgsDb) Failures(since time.Time) ([]LiteArgsDbFailure, error) {
+	return l.failures(`last_attempt_dt >= ? ORDER BY last_attempt_dt ASC, rowid ASC`, since.Format(time.DateTime))
+}
+
+// RunFailures returns rows which failed on their last attempt made by the run with the given id
+func (l *LiteArgsDb) RunFailures(runId string) ([]LiteArgsDbFailure, error) {
+	return l.failures(`last_run_id = ? ORDER BY rowid ASC`, runId)
+}
+
+func (l *LiteArgsDb) failures(condition string, args ...any) ([]LiteArgsDbFailure, error) {
+	rows, err := l.db.Query(
+		fmt.Sprintf(`SELECT rowid, attempts, last_stderr, last_attempt_dt, COALESCE(last_command, '') FROM liteargs WHERE succeed = 0 AND attempts > 0 AND %v`, condition),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get liteargs failures: %w", sqlError(err))
+	}
+	defer rows.Close()
+	failures := make([]LiteArgsDbFailure, 0)
+	for rows.Next() {
+		var failure LiteArgsDbFailure
+		err = rows.Scan(&failure.PrimaryKey, &failure.Attempts, &failure.LastStderr, &failure.LastAttemptDt, &failure.LastCommand)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse liteargs failure: %w", sqlError(err))
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+type LiteArgsDbMeta struct {
+	Key   string
+	Value string
+}
+
+func (l *LiteArgsDb) initMeta() error {
+	_, err := l.db.Exec(`CREATE TABLE IF NOT EXISTS liteargs_meta (key TEXT PRIMARY KEY, value TEXT)`)
+	if err != nil {
+		return fmt.Errorf("failed to create liteargs meta table: %w", sqlError(err))
+	}
+	return nil
+}
+
+// hasMeta checks if the liteargs_meta table exists, so reads don't create it
+func (l *LiteArgsDb) hasMeta() (bool, error) {
+	var tables int
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'liteargs_meta'`).Scan(&tables)
+	if err != nil {
+		return false, fmt.Errorf("failed to check liteargs meta table: %w", sqlError(err))
+	}
+	return tables > 0, nil
+}
+
+// SetMeta stores the value under the key in the liteargs_meta table, replacing the previous one
+func (l *LiteArgsDb) SetMeta(key, value string) error {
+	if err := l.initMeta(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`INSERT INTO liteargs_meta(key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to set liteargs meta: key=%v, err=%w", key, sqlError(err))
+	}
+	return nil
+}
+
+// GetMeta returns the value stored under the key in the liteargs_meta table
+func (l *LiteArgsDb) GetMeta(key string) (string, bool, error) {
+	exists, err := l.hasMeta()
+	if err != nil || !exists {
+		return "", false, err
+	}
+	var value string
+	err = l.db.QueryRow(`SELECT value FROM liteargs_meta WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to get liteargs meta: key=%v, err=%w", key, sqlError(err))
+	}
+	return value, true, nil
+}
+
+// Meta returns all entries from the liteargs_meta table ordered by key
+func (l *LiteArgsDb) Meta() ([]LiteArgsDbMeta, error) {
+	exists, err := l.hasMeta()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []LiteArgsDbMeta{}, nil
+	}
+	rows, err := l.db.Query(`SELECT key, value FROM liteargs_meta ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get liteargs meta: %w", sqlError(err))
+	}
+	defer rows.Close()
+	meta := make([]LiteArgsDbMeta, 0)
+	for rows.Next() {
+		var entry LiteArgsDbMeta
+		if err = rows.Scan(&entry.Key, &entry.Value); err != nil {
+			return nil, fmt.Errorf("failed to parse liteargs meta: %w", sqlError(err))
+		}
+		meta = append(meta, entry)
+	}
+	return meta, nil
+}
+
+type LiteArgsDbStats struct {
+	SqliteVersion string
+	Total         int
+	Succeed       int
+	Failed        int
+	Pending       int
+}
+
+func (l *LiteArgsDb) Stats() (LiteArgsDbStats, error) {
+	var stats LiteArgsDbStats
+	err := l.db.QueryRow(`SELECT sqlite_version()`).Scan(&stats.SqliteVersion)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get sqlite version: %w", sqlError(err))
+	}
+	if len(l.header) == 0 {
+		return stats, nil
+	}
+	err = l.db.QueryRow(`
+		SELECT 
+			COUNT(*), 
+			COALESCE(SUM(succeed = 1), 0), 
+			COALESCE(SUM(succeed = 0 AND attempts > 0), 0), 
+			COALESCE(SUM(succeed = 0 AND attempts = 0), 0) 
+		FROM liteargs`,
+	).Scan(&stats.Total, &stats.Succeed, &stats.Failed, &stats.Pending)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get liteargs stats: %w", sqlError(err))
+	}
+	return stats, nil
+}
+
+// Ping runs trivial query against the database and returns its latency
+func (l *LiteArgsDb) Ping(ctx context.Context) (time.Duration, error) {
+	startTime := time.Now()
+	var one int
+	if err := l.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return 0, fmt.Errorf("failed to ping liteargs state db: %w", sqlError(err))
+	}
+	return time.Since(startTime), nil
+}
+
+// MaxInt returns the greatest integer value of the column or zero if the liteargs table is empty
+func (l *LiteArgsDb) MaxInt(column string) (int64, error) {
+	var value int64
+	if err := l.db.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(CAST(%v AS INT)), 0) FROM liteargs`, column)).Scan(&value); err != nil {
+		return 0, fmt.Errorf("failed to get max liteargs %v: %w", column, sqlError(err))
+	}
+	return value, nil
+}
+
+type LiteArgsDbSchema struct {
+	// Statements are CREATE statements of tables and indexes stored in the state db
+	Statements []string
+	// UserColumns are columns with loaded arguments and StateColumns are bookkeeping columns maintained by liteargs
+	UserColumns  []string
+	StateColumns []string
+}
+
+// Schema returns definitions of all tables and indexes together with classification of the liteargs table columns
+func (l *LiteArgsDb) Schema() (LiteArgsDbSchema, error) {
+	schema := LiteArgsDbSchema{Statements: make([]string, 0), UserColumns: make([]string, 0), StateColumns: make([]string, 0)}
+	rows, err := l.db.Query(`SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND type IN ('table', 'index') ORDER BY type DESC, name ASC`)
+	if err != nil {
+		return schema, fmt.Errorf("failed to get liteargs schema: %w", sqlError(err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var statement string
+		if err = rows.Scan(&statement); err != nil {
+			return schema, fmt.Errorf("failed to parse liteargs schema: %w", sqlError(err))
+		}
+		schema.Statements = append(schema.Statements, statement)
+	}
+	columns, err := l.tableColumns()
+	if err != nil {
+		return schema, err
+	}
+	for _, column := range columns {
+		if isStateColumn(column) {
+			schema.StateColumns = append(schema.StateColumns, column)
+		} else {
+			schema.UserColumns = append(schema.UserColumns, column)
+		}
+	}
+	return schema, nil
+}
+
+// decimalRegex matches decimal literals which SQLite converts to REAL (ParseFloat also accepts NaN, Inf and hex floats)
+var decimalRegex = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// inferType returns INTEGER or REAL if all non-empty values are numbers of that kind and TEXT otherwise
+func inferType(values []any) string {
+	inferred := ""
+	for _, value := range values {
+		text := strings.TrimSpace(fmt.Sprint(exportValue(value)))
+		if value == nil || text == "" {
+			continue
+		}
+		// numbers with leading zeros (zip codes, ids) would lose them after conversion
+		if digits := strings.TrimLeft(text, "+-"); len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+			return "TEXT"
+		}
+		if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+			inferred = cmp.Or(inferred, "INTEGER")
+		} else if _, err = strconv.ParseFloat(text, 64); err == nil && decimalRegex.MatchString(text) {
+			inferred = "REAL"
+		} else {
+			return "TEXT"
+		}
+	}
+	return cmp.Or(inferred, "TEXT")
+}
+
+// InferTypes samples up to sample rows (all rows if it is not positive) and returns inferred type of every user column
+func (l *LiteArgsDb) InferTypes(sample int) (map[string]string, error) {
+	if len(l.header) == 0 {
+		return nil, fmt.Errorf("failed to infer liteargs types: %w", ErrNoTable)
+	}
+	query := fmt.Sprintf(`SELECT %v FROM liteargs ORDER BY rowid ASC`, l.columns)
+	if sample > 0 {
+		query = fmt.Sprintf("%v LIMIT %v", query, sample)
+	}
+	rows, err := l.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample liteargs rows: %w", sqlError(err))
+	}
+	defer rows.Close()
+	values := make([][]any, len(l.header))
+	for rows.Next() {
+		row := make([]any, len(l.header))
+		pointers := make([]any, len(l.header))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to parse liteargs row: %w", sqlError(err))
+		}
+		for i, value := range row {
+			values[i] = append(values[i], value)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to sample liteargs rows: %w", sqlError(err))
+	}
+	types := make(map[string]string, len(l.header))
+	for i, column := range l.header {
+		types[column] = inferType(values[i])
+	}
+	return types, nil
+}
+
+// Retype rebuilds the liteargs table with the given types of user columns preserving rowids and state of all rows
+func (l *LiteArgsDb) Retype(types map[string]string) error {
+	if len(l.header) == 0 {
+		return fmt.Errorf("failed to retype liteargs table: %w", ErrNoTable)
+	}
+	columns, err := l.tableColumns()
+	if err != nil {
+		return err
+	}
+	tx, err := l.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", sqlError(err))
+	}
+	defer func() { _ = tx.Rollback() }()
+	createStatement := fmt.Sprintf(`CREATE TABLE liteargs_retyped (%v)`, strings.Join(columnDefinitions(l.header, types), ", "))
+	if _, err = tx.Exec(createStatement); err != nil {
+		return fmt.Errorf("failed to create retyped liteargs table: %w", sqlError(err))
+	}
+	copied := strings.Join(append([]string{"rowid"}, columns...), ", ")
+	if _, err = tx.Exec(fmt.Sprintf(`INSERT INTO liteargs_retyped(%v) SELECT %v FROM liteargs`, copied, copied)); err != nil {
+		return fmt.Errorf("failed to copy liteargs rows: %w", sqlError(err))
+	}
+	if _, err = tx.Exec(`DROP TABLE liteargs`); err != nil {
+		return fmt.Errorf("failed to drop liteargs table: %w", sqlError(err))
+	}
+	if _, err = tx.Exec(`ALTER TABLE liteargs_retyped RENAME TO liteargs`); err != nil {
+		return fmt.Errorf("failed to rename retyped liteargs table: %w", sqlError(err))
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", sqlError(err))
+	}
+	return nil
+}
+
+type LiteArgsDbExitCodeCount struct {
+	// ExitCode is nil for rows attempted before exit codes were recorded
+	ExitCode *int64
+	Count    int
+}
+
+// ExitCodes returns histogram of exit codes among attempted rows with given success status
+func (l *LiteArgsDb) ExitCodes(succeed bool) ([]LiteArgsDbExitCodeCount, error) {
+	rows, err := l.db.Query(
+		`SELECT exit_code, COUNT(*) FROM liteargs WHERE succeed = ? AND attempts > 0 GROUP BY exit_code ORDER BY COUNT(*) DESC, exit_code ASC`,
+		succeed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get liteargs exit codes: %w", sqlError(err))
+	}
+	defer rows.Close()
+	histogram := make([]LiteArgsDbExitCodeCount, 0)
+	for rows.Next() {
+		var count LiteArgsDbExitCodeCount
+		if err = rows.Scan(&count.ExitCode, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to parse liteargs exit code: %w", sqlError(err))
+		}
+		histogram = append(histogram, count)
+	}
+	return histogram, nil
+}
+
+type LiteArgsDbValueCount struct {
+	Value any
+	Count int
+}
+
+// Distinct returns distinct values of the column together with amount of rows having them
+func (l *LiteArgsDb) Distinct(column string) ([]LiteArgsDbValueCount, error) {
+	columns, err := l.tableColumns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("failed to get distinct liteargs values: %w", ErrNoTable)
+	}
+	if !slices.Contains(columns, column) {
+		return nil, fmt.Errorf("column '%v' not found in columns: %v", column, columns)
+	}
+	rows, err := l.db.Query(fmt.Sprintf(`SELECT "%[1]v", COUNT(*) FROM liteargs GROUP BY "%[1]v" ORDER BY COUNT(*) DESC, "%[1]v" ASC`, column))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get distinct liteargs values: %w", sqlError(err))
+	}
+	defer rows.Close()
+	counts := make([]LiteArgsDbValueCount, 0)
+	for rows.Next() {
+		var count LiteArgsDbValueCount
+		if err = rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to parse distinct liteargs value: %w", sqlError(err))
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+type LiteArgsDbIn struct {
+	Column string
+	Values []string
+}
+
 type LiteArgsDbFilter struct {
 	Take   int
 	Filter string
-	Order  string
+	// FilterNot excludes rows matching the expression (rows where it evaluates to NULL are kept)
+	FilterNot string
+	Order     string
+	// OrderSecondary is appended to the order to resolve ties deterministically; rowid ASC is used when it is empty
+	OrderSecondary string
+	// PreserveOrder makes insertion order the default order of the rows instead of last attempt time
+	PreserveOrder bool
+	// PriorityColumn orders rows by the numeric column value descending (NULL is treated as 0) when Order is empty
+	PriorityColumn string
+	// Tag selects only rows loaded with the given tag if it is not empty
+	Tag string
+	// RowIds selects only rows with the given primary keys if it is not empty
+	RowIds []int64
+	// Since selects only rows loaded after the given time if it is not zero
+	Since time.Time
+	// RetryExitCodes selects only rows which weren't attempted yet or failed with one of the exit codes if it is not empty
+	RetryExitCodes []int
+	// IncludeDisabled selects rows which are disabled with the optional enabled column
+	IncludeDisabled bool
+	// IncludeLocked selects rows locked after the successful attempt, even when WhereRaw is set
+	IncludeLocked bool
+	// SkipRunning excludes rows which are marked as running by another process
+	SkipRunning bool
+	// In selects only rows where every listed column has one of the listed values
+	In []LiteArgsDbIn
+	// AfterRowId selects only rows with primary key greater than the given one if it is not zero
+	AfterRowId int64
+	// WhereRaw replaces Filter together with the forced succeed = 0 constraint, so already succeed rows can be selected
+	WhereRaw string
+	// StateColumns are bookkeeping columns selected next to the user columns
+	StateColumns []string
+	// Query replaces the composed query (its result must include the rowid column) and keeps its order
+	Query string
 }
 
-func (l *LiteArgsDb) Filter(filter LiteArgsDbFilter) ([]map[string]any, []any, error) {
+// validateClause rejects statement separators and comments outside of quoted literals
+func validateClause(name, clause string) error {
+	var quote rune
+	runes := []rune(clause)
+	for i, r := range runes {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == ';':
+			return fmt.Errorf("%v must be a single SQL expression, got statement separator: '%v'", name, clause)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-', r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			return fmt.Errorf("%v must be a single SQL expression, got comment: '%v'", name, clause)
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("%v has unterminated quote: '%v'", name, clause)
+	}
+	return nil
+}
+
+func (f LiteArgsDbFilter) validate() error {
+	if f.Filter != "" && f.WhereRaw != "" {
+		return fmt.Errorf("filter can't be used together with where-raw")
+	}
+	for _, column := range f.StateColumns {
+		if !isStateColumn(column) {
+			return fmt.Errorf("unknown state column: '%v'", column)
+		}
+	}
+	if f.Query != "" && (f.Order != "" || f.PreserveOrder || f.PriorityColumn != "") {
+		return fmt.Errorf("query can't be used together with order, preserve-order or priority-column as rows keep the query order")
+	}
+	if err := validateClause("query", f.Query); err != nil {
+		return err
+	}
+	if err := validateClause("filter", f.Filter); err != nil {
+		return err
+	}
+	if err := validateClause("where-raw", f.WhereRaw); err != nil {
+		return err
+	}
+	if err := validateClause("filter-not", f.FilterNot); err != nil {
+		return err
+	}
+	if err := validateClause("order", f.Order); err != nil {
+		return err
+	}
+	return validateClause("order-secondary", f.OrderSecondary)
+}
+
+func (l *LiteArgsDb) clauses(filter LiteArgsDbFilter) (string, string, int, []any) {
 	limit := filter.Take
 	if limit == 0 {
 		limit = -1
 	}
 	order := filter.Order
-	if order == "" {
+	if order == "" && filter.PreserveOrder {
+		order = "rowid ASC"
+	} else if order == "" {
 		order = "last_attempt_dt ASC"
 	}
+	if filter.PriorityColumn != "" && filter.Order == "" {
+		order = fmt.Sprintf(`COALESCE(CAST(NULLIF("%v", '') AS REAL), 0) DESC, %v`, strings.ReplaceAll(filter.PriorityColumn, `"`, `""`), order)
+	}
+	if secondary := cmp.Or(filter.OrderSecondary, "rowid ASC"); order != secondary {
+		order = fmt.Sprintf("%v, %v", order, secondary)
+	}
 	where := filter.Filter
 	if where == "" {
 		where = "1 = 1"
 	}
-	where = fmt.Sprintf("(%v) AND succeed = 0", where)
+	// values are passed as query arguments instead of being interpolated into the clause
+	var args []any
+	if filter.WhereRaw != "" {
+		where = fmt.Sprintf("(%v)", filter.WhereRaw)
+	} else {
+		where = fmt.Sprintf("(%v) AND succeed = 0", where)
+		if slices.Contains(l.header, enabledColumn) && !filter.IncludeDisabled {
+			where = fmt.Sprintf("%v AND COALESCE(%v, 1) NOT IN (0, '0', 'false')", where, enabledColumn)
+		}
+	}
+	if filter.FilterNot != "" {
+		where = fmt.Sprintf("%v AND NOT COALESCE((%v), 0)", where, filter.FilterNot)
+	}
+	if !filter.IncludeLocked {
+		where = fmt.Sprintf("%v AND COALESCE(locked, 0) = 0", where)
+	}
+	if filter.SkipRunning {
+		where = fmt.Sprintf("%v AND COALESCE(running, 0) = 0", where)
+	}
+	if filter.Tag != "" {
+		where = fmt.Sprintf("%v AND liteargs_tag = ?", where)
+		args = append(args, filter.Tag)
+	}
+	for _, in := range filter.In {
+		for _, value := range in.Values {
+			args = append(args, value)
+		}
+		where = fmt.Sprintf(`%v AND "%v" IN (%v)`, where, strings.ReplaceAll(in.Column, `"`, `""`), strings.Join(repeat("?", len(in.Values)), ", "))
+	}
+	if !filter.Since.IsZero() {
+		where = fmt.Sprintf("%v AND loaded_at > ?", where)
+		args = append(args, filter.Since.Format(loadedAtLayout))
+	}
+	if len(filter.RetryExitCodes) > 0 {
+		for _, exitCode := range filter.RetryExitCodes {
+			args = append(args, exitCode)
+		}
+		where = fmt.Sprintf("%v AND (attempts = 0 OR exit_code IN (%v))", where, strings.Join(repeat("?", len(filter.RetryExitCodes)), ", "))
+	}
+	if len(filter.RowIds) > 0 {
+		// rowids are passed as a single json array as their amount can exceed the limit of query arguments
+		rowIds, _ := json.Marshal(filter.RowIds)
+		where = fmt.Sprintf("%v AND rowid IN (SELECT value FROM json_each(?))", where)
+		args = append(args, string(rowIds))
+	}
+	if filter.AfterRowId > 0 {
+		where = fmt.Sprintf("%v AND rowid > ?", where)
+		args = append(args, filter.AfterRowId)
+	}
+	return where, order, limit, args
+}
 
-	rows, err := l.db.Query(fmt.Sprintf(`SELECT rowid, %v FROM liteargs WHERE %v ORDER BY %v LIMIT %v`, l.columns, where, order, limit))
+// Delete removes rows selected by the filter together with their attempts history and returns amount of deleted rows
+func (l *LiteArgsDb) Delete(filter LiteArgsDbFilter) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	if len(l.header) == 0 {
+		return 0, fmt.Errorf("failed to delete liteargs rows: %w", ErrNoTable)
+	}
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", sqlError(err))
+	}
+	defer func() { _ = tx.Rollback() }()
+	query, args := l.FilterQuery(filter)
+	selected := fmt.Sprintf("SELECT rowid FROM (%v)", query)
+	var history int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'liteargs_attempts'`).Scan(&history)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check liteargs attempts table: %w", sqlError(err))
+	}
+	if history > 0 {
+		if _, err = tx.Exec(fmt.Sprintf("DELETE FROM liteargs_attempts WHERE liteargs_rowid IN (%v)", selected), args...); err != nil {
+			return 0, fmt.Errorf("failed to delete liteargs attempts: %w", sqlError(err))
+		}
+	}
+	result, err := tx.Exec(fmt.Sprintf("DELETE FROM liteargs WHERE rowid IN (%v)", selected), args...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get liteargs rows: filter='%v', order='%v', limit='%v', err=%w", where, order, limit, err)
+		return 0, fmt.Errorf("failed to delete liteargs rows: %w", sqlError(err))
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get amount of deleted rows: %w", sqlError(err))
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", sqlError(err))
+	}
+	return int(deleted), nil
+}
+
+// FilterQuery returns SQL query composed by the Filter method for the given filter together with its arguments
+func (l *LiteArgsDb) FilterQuery(filter LiteArgsDbFilter) (string, []any) {
+	where, order, limit, args := l.clauses(filter)
+	if filter.Query != "" {
+		return fmt.Sprintf(`SELECT * FROM (%v) WHERE rowid IN (SELECT rowid FROM liteargs WHERE %v) LIMIT %v`, filter.Query, where, limit), args
+	}
+	columns := l.columns
+	if len(filter.StateColumns) > 0 {
+		columns = fmt.Sprintf("%v, %v", columns, strings.Join(filter.StateColumns, ", "))
+	}
+	return fmt.Sprintf(`SELECT rowid, %v FROM liteargs WHERE %v ORDER BY %v LIMIT %v`, columns, where, order, limit), args
+}
+
+// Explain returns the SQLite query plan for the query composed by the Filter method
+func (l *LiteArgsDb) Explain(filter LiteArgsDbFilter) ([]string, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	query, args := l.FilterQuery(filter)
+	rows, err := l.db.Query(fmt.Sprintf("EXPLAIN QUERY PLAN %v", query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain liteargs query: %w", sqlError(err))
+	}
+	defer rows.Close()
+	plan := make([]string, 0)
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		err = rows.Scan(&id, &parent, &notUsed, &detail)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse query plan row: %w", sqlError(err))
+		}
+		plan = append(plan, detail)
+	}
+	return plan, nil
+}
+
+// Count returns amount of rows selected by the filter
+func (l *LiteArgsDb) Count(filter LiteArgsDbFilter) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	if len(l.header) == 0 {
+		return 0, fmt.Errorf("failed to count liteargs rows: %w", ErrNoTable)
+	}
+	var count int
+	query, args := l.FilterQuery(filter)
+	if err := l.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%v)", query), args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count liteargs rows: %w", sqlError(err))
+	}
+	return count, nil
+}
+
+// Filter returns pending rows selected by the filter together with their primary keys
+func (l *LiteArgsDb) Filter(filter LiteArgsDbFilter) ([]map[string]any, []any, error) {
+	if len(l.header) == 0 {
+		return nil, nil, fmt.Errorf("failed to get liteargs rows: %w", ErrNoTable)
+	}
+	if err := filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	where, order, limit, _ := l.clauses(filter)
+	query, args := l.FilterQuery(filter)
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get liteargs rows: filter='%v', order='%v', limit='%v', err=%w", where, order, limit, sqlError(err))
 	}
 	defer rows.Close()
 	results := make([]map[string]any, 0)
 	primaryKeys := make([]any, 0)
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get results columns: %w", err)
+		return nil, nil, fmt.Errorf("failed to get results columns: %w", sqlError(err))
+	}
+	if !slices.Contains(columns, "rowid") {
+		return nil, nil, fmt.Errorf("failed to get liteargs rows: query result must include rowid column, got: %v", columns)
 	}
 	for rows.Next() {
 		values := make([]any, len(columns))
@@ -182,7 +1372,7 @@ func (l *LiteArgsDb) Filter(filter LiteArgsDbFilter) ([]map[string]any, []any, e
 		}
 		err = rows.Scan(refs...)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse litearg row: err=%w", err)
+			return nil, nil, fmt.Errorf("failed to parse litearg row: err=%w", sqlError(err))
 		}
 		result := make(map[string]any, len(columns))
 		for i, column := range columns {
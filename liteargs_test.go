@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestLiteArgs(t *testing.T) {
@@ -47,3 +55,546 @@ func TestLiteArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestLiteArgsClaim(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.db")
+	db, err := NewLiteArgsDb(file)
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	for i := 0; i < 100; i++ {
+		require.Nil(t, db.Insert([]string{fmt.Sprintf("n-%v", i)}))
+	}
+
+	claimed := make([][]any, 2)
+	var group errgroup.Group
+	for i := range claimed {
+		group.Go(func() error {
+			workerDb, err := NewLiteArgsDb(file)
+			if err != nil {
+				return err
+			}
+			_, pks, err := workerDb.Filter(LiteArgsDbFilter{})
+			if err != nil {
+				return err
+			}
+			claimed[i], err = workerDb.Claim(pks, fmt.Sprintf("worker-%v", i))
+			return err
+		})
+	}
+	require.Nil(t, group.Wait())
+
+	seen := make(map[any]bool)
+	for _, pks := range claimed {
+		for _, pk := range pks {
+			require.False(t, seen[pk], "rowid %v claimed twice", pk)
+			seen[pk] = true
+		}
+	}
+	require.Len(t, seen, 100)
+
+	again, err := db.Claim([]any{int64(1), int64(2)}, "worker-2")
+	require.Nil(t, err)
+	require.Empty(t, again)
+}
+
+func TestLiteArgsPreserveOrder(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n-1"}))
+	require.Nil(t, db.Insert([]string{"n-2"}))
+	require.Nil(t, db.Insert([]string{"n-3"}))
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Time: time.Date(2024, 8, 10, 0, 0, 2, 0, time.UTC)}))
+	require.Nil(t, db.Update(int64(2), LiteArgsDbUpdate{Time: time.Date(2024, 8, 10, 0, 0, 1, 0, time.UTC)}))
+	{
+		result, _, err := db.Filter(LiteArgsDbFilter{})
+		require.Nil(t, err)
+		require.Equal(t, result, []map[string]any{
+			{"rowid": int64(3), "name": "n-3"},
+			{"rowid": int64(2), "name": "n-2"},
+			{"rowid": int64(1), "name": "n-1"},
+		})
+	}
+	{
+		result, _, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+		require.Nil(t, err)
+		require.Equal(t, result, []map[string]any{
+			{"rowid": int64(1), "name": "n-1"},
+			{"rowid": int64(2), "name": "n-2"},
+			{"rowid": int64(3), "name": "n-3"},
+		})
+	}
+}
+
+func TestLiteArgsOrderSecondary(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	for _, name := range []string{"c", "a", "d", "b"} {
+		require.Nil(t, db.Insert([]string{name}))
+	}
+	names := func(filter LiteArgsDbFilter) []string {
+		result, _, err := db.Filter(filter)
+		require.Nil(t, err)
+		names := make([]string, len(result))
+		for i, row := range result {
+			names[i] = row["name"].(string)
+		}
+		return names
+	}
+	for range 3 {
+		require.Equal(t, []string{"c", "a", "d", "b"}, names(LiteArgsDbFilter{}))
+		require.Equal(t, []string{"d", "c", "b", "a"}, names(LiteArgsDbFilter{OrderSecondary: "name DESC"}))
+	}
+	require.Equal(t, []string{"c", "a"}, names(LiteArgsDbFilter{Order: "attempts ASC", Take: 2}))
+	query, _ := db.FilterQuery(LiteArgsDbFilter{})
+	require.Contains(t, query, "ORDER BY last_attempt_dt ASC, rowid ASC")
+	query, _ = db.FilterQuery(LiteArgsDbFilter{PreserveOrder: true})
+	require.Contains(t, query, "ORDER BY rowid ASC LIMIT")
+
+	_, _, err = db.Filter(LiteArgsDbFilter{OrderSecondary: "name; DROP TABLE liteargs"})
+	require.NotNil(t, err)
+}
+
+func TestLiteArgsInferTypes(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name", "size", "ratio"}))
+	for _, record := range [][]string{{"a", "10", "0.5"}, {"b", "9", "1"}, {"c", "100", ""}, {"d", "", "2.25"}} {
+		require.Nil(t, db.Insert(record))
+	}
+	require.Nil(t, db.Update(int64(2), LiteArgsDbUpdate{Succeed: true, Stdout: "ok"}))
+
+	sizes := func() []any {
+		result, _, err := db.Filter(LiteArgsDbFilter{WhereRaw: "size != ''", Order: "size ASC"})
+		require.Nil(t, err)
+		sizes := make([]any, len(result))
+		for i, row := range result {
+			sizes[i] = row["size"]
+		}
+		return sizes
+	}
+	require.Equal(t, []any{"10", "100", "9"}, sizes())
+
+	types, err := db.InferTypes(0)
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"name": "TEXT", "size": "INTEGER", "ratio": "REAL"}, types)
+	require.Nil(t, db.Retype(types))
+	require.Equal(t, []any{int64(9), int64(10), int64(100)}, sizes())
+
+	_, row, err := db.Get(int64(2))
+	require.Nil(t, err)
+	require.Equal(t, "b", row["name"])
+	require.Equal(t, int64(1), row["succeed"])
+	require.Equal(t, "ok", row["last_stdout"])
+
+	schema, err := db.Schema()
+	require.Nil(t, err)
+	require.Contains(t, schema.Statements[0], "size INTEGER")
+
+	types, err = db.InferTypes(1)
+	require.Nil(t, err)
+	require.Equal(t, "REAL", types["ratio"])
+
+	require.Equal(t, "TEXT", inferType([]any{"10501", "00501"}))
+	require.Equal(t, "TEXT", inferType([]any{"-007"}))
+	require.Equal(t, "TEXT", inferType([]any{"01.5"}))
+	require.Equal(t, "INTEGER", inferType([]any{"0", "-0", "10"}))
+	require.Equal(t, "REAL", inferType([]any{"0.5", "-0.25"}))
+	require.Equal(t, "REAL", inferType([]any{"1e3", ".5", "2.", "-1.5E-3", 0.25}))
+	for _, value := range []string{"NaN", "nan", "inf", "-Inf", "Infinity", "0x1p-2", "0x10", "1_000.5"} {
+		require.Equal(t, "TEXT", inferType([]any{"1.5", value}), value)
+	}
+}
+
+func TestLiteArgsSkipRunning(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	for _, name := range []string{"n-1", "n-2", "n-3"} {
+		require.Nil(t, db.Insert([]string{name}))
+	}
+	claimed, err := db.Claim([]any{int64(2)}, "other")
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, claimed)
+
+	_, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(1), int64(2), int64(3)}, pks)
+	_, pks, err = db.Filter(LiteArgsDbFilter{PreserveOrder: true, SkipRunning: true})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(1), int64(3)}, pks)
+}
+
+func TestLiteArgsQuery(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name", "parent"}))
+	for _, record := range [][]string{{"root", ""}, {"a", "root"}, {"b", "root"}, {"c", "a"}} {
+		require.Nil(t, db.Insert(record))
+	}
+	query := `SELECT child.rowid, child.name, parent.rowid AS parent_rowid FROM liteargs child JOIN liteargs parent ON child.parent = parent.name WHERE parent.parent = '' ORDER BY child.rowid`
+	rows, pks, err := db.Filter(LiteArgsDbFilter{Query: query})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2), int64(3)}, pks)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(2), "name": "a", "parent_rowid": int64(1)},
+		{"rowid": int64(3), "name": "b", "parent_rowid": int64(1)},
+	}, rows)
+	count, err := db.Count(LiteArgsDbFilter{Query: query})
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+
+	_, _, err = db.Filter(LiteArgsDbFilter{Query: "SELECT name FROM liteargs"})
+	require.ErrorContains(t, err, "no such column: rowid")
+	_, _, err = db.Filter(LiteArgsDbFilter{Query: "SELECT ROWID AS ROWID, name FROM liteargs"})
+	require.ErrorContains(t, err, "query result must include rowid column")
+	_, _, err = db.Filter(LiteArgsDbFilter{Query: "SELECT rowid FROM liteargs; DELETE FROM liteargs"})
+	require.NotNil(t, err)
+	_, _, err = db.Filter(LiteArgsDbFilter{Query: query, Order: "name DESC"})
+	require.ErrorContains(t, err, "query can't be used together with order")
+
+	// other selection fields narrow down rows of the query
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query, Filter: "name = 'b'"})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(3)}, pks)
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query, Take: 1})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, pks)
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query, RowIds: []int64{1, 3}})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(3)}, pks)
+	require.Nil(t, db.Update(int64(2), LiteArgsDbUpdate{Succeed: true, Lock: true}))
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(3)}, pks)
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query, WhereRaw: "1 = 1"})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(3)}, pks)
+	_, pks, err = db.Filter(LiteArgsDbFilter{Query: query, WhereRaw: "1 = 1", IncludeLocked: true})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2), int64(3)}, pks)
+}
+
+func TestLiteArgsPriorityColumn(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name", "priority"}))
+	for _, record := range [][]string{{"low", "-1"}, {"default", ""}, {"high", "10"}, {"medium", "9"}, {"zero", "0"}} {
+		require.Nil(t, db.Insert(record))
+	}
+	require.Nil(t, db.InsertValues([]any{"null", nil}))
+	names := func(filter LiteArgsDbFilter) []string {
+		result, _, err := db.Filter(filter)
+		require.Nil(t, err)
+		names := make([]string, len(result))
+		for i, row := range result {
+			names[i] = row["name"].(string)
+		}
+		return names
+	}
+	require.Equal(t, []string{"high", "medium", "default", "zero", "null", "low"}, names(LiteArgsDbFilter{PriorityColumn: "priority", PreserveOrder: true}))
+	require.Equal(t, []string{"high", "medium"}, names(LiteArgsDbFilter{PriorityColumn: "priority", Take: 2}))
+	require.Equal(t, []string{"low", "default"}, names(LiteArgsDbFilter{PriorityColumn: "priority", Order: "rowid ASC", Take: 2}))
+
+	require.Nil(t, db.Update(int64(3), LiteArgsDbUpdate{Succeed: true}))
+	require.Equal(t, []string{"medium", "default"}, names(LiteArgsDbFilter{PriorityColumn: "priority", Take: 2}))
+}
+
+func TestLiteArgsFilterValidation(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n;1"}))
+
+	for _, filter := range []LiteArgsDbFilter{
+		{Filter: "1 = 1; DROP TABLE liteargs"},
+		{Filter: "1 = 1 -- comment"},
+		{Filter: "1 = 1 /* comment */"},
+		{Filter: "name = 'unterminated"},
+		{Order: "name; DROP TABLE liteargs"},
+		{WhereRaw: "1 = 1; DROP TABLE liteargs"},
+		{FilterNot: "1 = 1; DROP TABLE liteargs"},
+		{Filter: "1 = 1", WhereRaw: "1 = 1"},
+	} {
+		_, _, err = db.Filter(filter)
+		require.NotNil(t, err, "filter=%+v", filter)
+	}
+
+	result, _, err := db.Filter(LiteArgsDbFilter{Filter: "name = 'n;1' OR name = '--'"})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n;1"}}, result)
+}
+
+func TestLiteArgsWhereRaw(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n-1"}))
+	require.Nil(t, db.Insert([]string{"n-2"}))
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, Time: time.Now()}))
+
+	result, _, err := db.Filter(LiteArgsDbFilter{Filter: "name = 'n-1'"})
+	require.Nil(t, err)
+	require.Empty(t, result)
+
+	result, _, err = db.Filter(LiteArgsDbFilter{WhereRaw: "succeed = 1 AND attempts > 0"})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n-1"}}, result)
+}
+
+func TestLiteArgsFilterNot(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"url"}))
+	for _, url := range []string{"a.test", "b.com", "c.test", "d.org"} {
+		require.Nil(t, db.Insert([]string{url}))
+	}
+	require.Nil(t, db.InsertValues([]any{nil}))
+
+	rowIds := func(filter LiteArgsDbFilter) []any {
+		_, pks, err := db.Filter(filter)
+		require.Nil(t, err)
+		return pks
+	}
+	require.Equal(t, []any{int64(1), int64(3)}, rowIds(LiteArgsDbFilter{Filter: "url LIKE '%.test'", PreserveOrder: true}))
+	require.Equal(t, []any{int64(2), int64(4), int64(5)}, rowIds(LiteArgsDbFilter{FilterNot: "url LIKE '%.test'", PreserveOrder: true}))
+	require.Equal(t, []any{int64(4)}, rowIds(LiteArgsDbFilter{Filter: "url LIKE 'd%' OR url LIKE 'a%'", FilterNot: "url LIKE '%.test'"}))
+}
+
+func TestLiteArgsIn(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"host", "port"}))
+	for _, record := range [][]string{{"a", "80"}, {"b", "443"}, {"o'neil", "80"}, {"c,d", "80"}, {"e", "80"}} {
+		require.Nil(t, db.Insert(record))
+	}
+	rowIds := func(in ...LiteArgsDbIn) []any {
+		_, pks, err := db.Filter(LiteArgsDbFilter{In: in, PreserveOrder: true})
+		require.Nil(t, err)
+		return pks
+	}
+	require.Equal(t, []any{int64(1), int64(2), int64(3), int64(4)}, rowIds(LiteArgsDbIn{Column: "host", Values: []string{"a", "b", "o'neil", "c,d"}}))
+	require.Equal(t, []any{int64(1), int64(4)}, rowIds(LiteArgsDbIn{Column: "host", Values: []string{"a", "b", "c,d"}}, LiteArgsDbIn{Column: "port", Values: []string{"80"}}))
+	require.Empty(t, rowIds(LiteArgsDbIn{Column: "host", Values: []string{"') OR ('1' = '1"}}))
+
+	query, args := db.FilterQuery(LiteArgsDbFilter{In: []LiteArgsDbIn{{Column: "host", Values: []string{"o'neil"}}}, RowIds: []int64{3, 4}})
+	require.NotContains(t, query, "neil")
+	require.Equal(t, []any{"o'neil", "[3,4]"}, args)
+	manyRowIds := make([]int64, 40000)
+	for i := range manyRowIds {
+		manyRowIds[i] = int64(i + 1)
+	}
+	_, pks, err := db.Filter(LiteArgsDbFilter{In: []LiteArgsDbIn{{Column: "port", Values: []string{"443"}}}, RowIds: manyRowIds})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, pks)
+}
+
+func TestLiteArgsEnabled(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name", "enabled"}))
+	for _, record := range [][]any{{"n-1", "1"}, {"n-2", "0"}, {"n-3", nil}, {"n-4", "false"}, {"n-5", 0}} {
+		require.Nil(t, db.InsertValues(record))
+	}
+
+	result, _, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "name": "n-1", "enabled": "1"},
+		{"rowid": int64(3), "name": "n-3", "enabled": nil},
+	}, result)
+
+	result, _, err = db.Filter(LiteArgsDbFilter{IncludeDisabled: true})
+	require.Nil(t, err)
+	require.Len(t, result, 5)
+}
+
+func TestLiteArgsKeepHistory(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n-1"}))
+
+	attemptTime := time.Date(2024, 8, 10, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{ExitCode: 1, Stderr: "no history", Time: attemptTime}))
+	var tables int
+	require.Nil(t, db.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'liteargs_attempts'`).Scan(&tables))
+	require.Equal(t, 0, tables)
+
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{ExitCode: 2, Stderr: "failed", Time: attemptTime, KeepHistory: true}))
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, Stdout: "ok", Time: attemptTime, KeepHistory: true}))
+	rows, err := db.db.Query(`SELECT attempt, succeed, exit_code, stdout, stderr FROM liteargs_attempts WHERE liteargs_rowid = 1 ORDER BY attempt`)
+	require.Nil(t, err)
+	defer rows.Close()
+	type attempt struct {
+		attempt, succeed, exitCode int
+		stdout, stderr             string
+	}
+	attempts := make([]attempt, 0)
+	for rows.Next() {
+		var a attempt
+		require.Nil(t, rows.Scan(&a.attempt, &a.succeed, &a.exitCode, &a.stdout, &a.stderr))
+		attempts = append(attempts, a)
+	}
+	require.Equal(t, []attempt{
+		{attempt: 2, succeed: 0, exitCode: 2, stderr: "failed"},
+		{attempt: 3, succeed: 1, exitCode: 0, stdout: "ok"},
+	}, attempts)
+}
+
+func TestLiteArgsDelete(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	for _, name := range []string{"n-1", "n-2", "gone-3", "gone-4"} {
+		require.Nil(t, db.Insert([]string{name}))
+	}
+	attemptTime := time.Date(2024, 8, 10, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, db.Update(int64(3), LiteArgsDbUpdate{Succeed: true, Time: attemptTime, KeepHistory: true}))
+
+	_, err = db.Delete(LiteArgsDbFilter{WhereRaw: "1; DROP TABLE liteargs"})
+	require.NotNil(t, err)
+
+	deleted, err := db.Delete(LiteArgsDbFilter{WhereRaw: "name LIKE 'gone-%'"})
+	require.Nil(t, err)
+	require.Equal(t, 2, deleted)
+	count, err := db.Count(LiteArgsDbFilter{WhereRaw: "1 = 1"})
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	var history int
+	require.Nil(t, db.db.QueryRow(`SELECT COUNT(*) FROM liteargs_attempts`).Scan(&history))
+	require.Equal(t, 0, history)
+
+	deleted, err = db.Delete(LiteArgsDbFilter{WhereRaw: "name = 'missing'"})
+	require.Nil(t, err)
+	require.Equal(t, 0, deleted)
+}
+
+func TestLiteArgsPing(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	latency, err := db.Ping(context.Background())
+	require.Nil(t, err)
+	require.Greater(t, latency, time.Duration(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Ping(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	_, err = OpenLiteArgsDb(missing)
+	require.NotNil(t, err)
+	require.NoFileExists(t, missing)
+
+	path := filepath.Join(t.TempDir(), "state#1?.db")
+	db, err = NewLiteArgsDb(path)
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.FileExists(t, path)
+	db, err = OpenLiteArgsDb(path)
+	require.Nil(t, err)
+	_, err = db.Ping(context.Background())
+	require.Nil(t, err)
+
+	authToken = "secret"
+	t.Cleanup(func() { authToken = "" })
+	dsn, err := stateDbDsn("libsql://db.turso.io", true)
+	require.Nil(t, err)
+	require.Equal(t, "libsql://db.turso.io?authToken=secret", dsn)
+}
+
+func TestLiteArgsExtensions(t *testing.T) {
+	t.Cleanup(func() { extensions = nil })
+	extensions = []string{filepath.Join(t.TempDir(), "missing.so")}
+	_, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.NotNil(t, err)
+
+	// LITEARGS_TEST_REGEXP_EXTENSION must point to extension providing regexp function (e.g. sqlean regexp)
+	extension := os.Getenv("LITEARGS_TEST_REGEXP_EXTENSION")
+	if extension == "" {
+		t.Skip("LITEARGS_TEST_REGEXP_EXTENSION isn't set")
+	}
+	extensions = []string{extension}
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"url"}))
+	require.Nil(t, db.Insert([]string{"https://a.test/1"}))
+	require.Nil(t, db.Insert([]string{"https://b.com/2"}))
+	_, pks, err := db.Filter(LiteArgsDbFilter{Filter: `url REGEXP '^https://[a-z]+\.test/'`})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(1)}, pks)
+}
+
+func TestLiteArgsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := NewLiteArgsDb(path)
+	require.Nil(t, err)
+	_, _, err = db.Filter(LiteArgsDbFilter{})
+	require.ErrorIs(t, err, ErrNoTable)
+	_, _, err = db.Get(int64(1))
+	require.ErrorIs(t, err, ErrNoTable)
+	require.ErrorIs(t, db.Reset("", "", false), ErrNoTable)
+
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n-1"}))
+	_, _, err = db.Get(int64(2))
+	require.ErrorIs(t, err, ErrRowNotFound)
+	require.ErrorIs(t, db.Update(int64(2), LiteArgsDbUpdate{Time: time.Now()}), ErrRowNotFound)
+
+	other, err := sql.Open("libsql", fmt.Sprintf("file:%v", path))
+	require.Nil(t, err)
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	require.Nil(t, err)
+	defer func() { _, _ = conn.ExecContext(context.Background(), "ROLLBACK") }()
+
+	db.db.SetMaxOpenConns(1)
+	_, err = db.db.Exec("PRAGMA busy_timeout = 10")
+	require.Nil(t, err)
+	require.ErrorIs(t, db.Reset("", "", false), ErrLocked)
+}
+
+func TestLiteArgsSchema(t *testing.T) {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name", "url"}))
+	require.Nil(t, db.SetMeta("key", "value"))
+
+	schema, err := db.Schema()
+	require.Nil(t, err)
+	require.Equal(t, []string{"name", "url"}, schema.UserColumns)
+	require.Contains(t, schema.StateColumns, "succeed")
+	require.Contains(t, schema.StateColumns, "attempts")
+	require.Len(t, schema.StateColumns, len(stateColumns))
+	require.Len(t, schema.Statements, 2)
+	require.True(t, strings.HasPrefix(schema.Statements[0], "CREATE TABLE liteargs ("))
+	require.True(t, strings.HasPrefix(schema.Statements[1], "CREATE TABLE liteargs_meta ("))
+}
+
+func TestLiteArgsMetaReadOnly(t *testing.T) {
+	db, err := NewLiteArgsDb(":memory:")
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	_, ok, err := db.GetMeta("input")
+	require.Nil(t, err)
+	require.False(t, ok)
+	meta, err := db.Meta()
+	require.Nil(t, err)
+	require.Empty(t, meta)
+	schema, err := db.Schema()
+	require.Nil(t, err)
+	for _, statement := range schema.Statements {
+		require.NotContains(t, statement, "liteargs_meta")
+	}
+
+	require.Nil(t, db.SetMeta("input", "data.csv"))
+	value, ok, err := db.GetMeta("input")
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "data.csv", value)
+}
@@ -1,14 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"text/template"
@@ -19,44 +34,85 @@ import (
 	"github.com/libsql/libsql-shell-go/pkg/shell"
 	_ "github.com/libsql/libsql-shell-go/pkg/shell"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 )
 
 var (
 	errorHeader = color.New(color.FgRed, color.Bold)
+	warnHeader  = color.New(color.FgYellow, color.Bold)
 	infoHeader  = color.New(color.FgHiWhite, color.Bold)
 	traceHeader = color.New(color.FgWhite, color.Italic)
 	okHeader    = color.New(color.FgGreen, color.Italic)
 )
 
+// logWriter is the destination of all liteargs logs
+var logWriter io.Writer = os.Stderr
+
+// logMasks match sensitive substrings which are redacted from all logs
+var logMasks []*regexp.Regexp
+
+// compileMasks compiles masks as regular expressions falling back to literal match for invalid expressions
+func compileMasks(masks []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(masks))
+	for _, mask := range masks {
+		expression, err := regexp.Compile(mask)
+		if err != nil {
+			expression = regexp.MustCompile(regexp.QuoteMeta(mask))
+		}
+		compiled = append(compiled, expression)
+	}
+	return compiled
+}
+
+func writeLog(header *color.Color, level string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	for _, mask := range logMasks {
+		message = mask.ReplaceAllString(message, "***")
+	}
+	_, _ = fmt.Fprintf(logWriter, "%v%v\n", header.Sprintf(level), message)
+}
+
 func fatalLog(format string, args ...any) {
 	errorLog(format, args...)
 	os.Exit(1)
 }
 
 func errorLog(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%v%v\n", errorHeader.Sprintf("error: "), fmt.Sprintf(format, args...))
+	writeLog(errorHeader, "error: ", format, args...)
+}
+
+func warnLog(format string, args ...any) {
+	writeLog(warnHeader, "warn : ", format, args...)
 }
 
 func infoLog(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%v%v\n", infoHeader.Sprintf("info : "), fmt.Sprintf(format, args...))
+	writeLog(infoHeader, "info : ", format, args...)
 }
 
 func okLog(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%v%v\n", okHeader.Sprintf("ok   : "), fmt.Sprintf(format, args...))
+	writeLog(okHeader, "ok   : ", format, args...)
 }
 
 func traceLog(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%v%v\n", traceHeader.Sprintf("trace: "), fmt.Sprintf(format, args...))
+	writeLog(traceHeader, "trace: ", format, args...)
+}
+
+var separatorAliases = map[string]rune{
+	"\\t":       '\t',
+	"tab":       '\t',
+	"pipe":      '|',
+	"semicolon": ';',
+	"space":     ' ',
 }
 
 func separator(s string) rune {
-	if s == "\\t" {
-		return '\t'
+	if r, ok := separatorAliases[s]; ok {
+		return r
 	}
 	runes := []rune(s)
 	if len(runes) != 1 {
-		fatalLog("separate must be a single character, got: '%v'", s)
+		fatalLog("separator must be a single character or one of tab, pipe, semicolon, space, got: '%v'", s)
 	}
 	return runes[0]
 }
@@ -77,27 +133,185 @@ func anyArray[T any](a []T) []any {
 	return r
 }
 
-func input(file string) io.ReadCloser {
-	if file != "" {
-		reader, err := os.Open(file)
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return home
+}
+
+func defaultWorker() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%v-%v", hostname, os.Getpid())
+}
+
+// onlyClaimed keeps only rows which primary keys were successfully claimed
+func onlyClaimed(rows []map[string]any, claimed []any) ([]map[string]any, []any) {
+	claimedSet := make(map[any]struct{}, len(claimed))
+	for _, primaryKey := range claimed {
+		claimedSet[primaryKey] = struct{}{}
+	}
+	filteredRows := make([]map[string]any, 0, len(claimed))
+	filteredPks := make([]any, 0, len(claimed))
+	for _, row := range rows {
+		if _, ok := claimedSet[row["rowid"]]; ok {
+			filteredRows = append(filteredRows, row)
+			filteredPks = append(filteredPks, row["rowid"])
+		}
+	}
+	return filteredRows, filteredPks
+}
+
+// executeClaimed claims rows for the worker, runs only claimed ones and releases claimed rows which weren't updated by the run
+func executeClaimed(db *LiteArgsDb, worker string, rows []map[string]any, pks []any, run func(rows []map[string]any, pks []any) (execSummary, error)) (execSummary, error) {
+	claimed, err := db.Claim(pks, worker)
+	if err != nil {
+		return execSummary{}, err
+	}
+	infoLog("claimed %v rows out of %v selected by worker %v", len(claimed), len(pks), worker)
+	rows, pks = onlyClaimed(rows, claimed)
+	summary, err := run(rows, pks)
+	released, releaseErr := db.Release(claimed, worker)
+	if released > 0 {
+		infoLog("released %v claimed rows which weren't executed by worker %v", released, worker)
+	}
+	return summary, cmp.Or(err, releaseErr)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isURL reports whether the input must be fetched over HTTP(S) instead of opened as a local file
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// input opens local file, HTTP(S) URL or stdin (if file is empty) and optionally decompresses gzip stream
+func input(ctx context.Context, file string, gzipped bool) (io.ReadCloser, error) {
+	var reader io.ReadCloser = io.NopCloser(os.Stdin)
+	if isURL(file) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, file, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for input url %v: %w", file, err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch input url %v: %w", file, err)
+		}
+		if response.StatusCode != http.StatusOK {
+			_ = response.Body.Close()
+			return nil, fmt.Errorf("failed to fetch input url %v: unexpected status %v", file, response.Status)
+		}
+		reader = response.Body
+	} else if file != "" {
+		opened, err := os.Open(file)
 		if err != nil {
-			fatalLog("failed to open input file %v: %v", file, err)
+			return nil, fmt.Errorf("failed to open input file %v: %w", file, err)
 		}
-		return reader
+		reader = opened
+	}
+	if !gzipped {
+		return reader, nil
+	}
+	decompressed, err := gzip.NewReader(reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("failed to read gzip input: %w", err)
 	}
-	return io.NopCloser(os.Stdin)
+	return readCloser{Reader: decompressed, Closer: reader}, nil
 }
 
-func render(command string, rows []map[string]any) ([]string, error) {
-	t, err := template.New("liteargs").Parse(command)
+// commandTemplate joins all positional command arguments into the single template
+func commandTemplate(args []string) string {
+	return strings.Join(args, " ")
+}
+
+// columnTemplate returns template which renders literal value of the column (NULL is rendered as nullValue)
+func columnTemplate(column string) string {
+	left, right := cmp.Or(leftDelim, "{{"), cmp.Or(rightDelim, "}}")
+	return fmt.Sprintf("%vindex . %q%v", left, column, right)
+}
+
+var templateFuncs = template.FuncMap{
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+}
+
+// leftDelim and rightDelim override default {{ and }} template action delimiters when set
+var leftDelim, rightDelim string
+
+// nullValue is rendered in templates instead of NULL column values (which text/template renders as <no value>)
+var nullValue string
+
+// templateRow returns the row with NULL values replaced by nullValue
+func templateRow(row map[string]any) map[string]any {
+	converted := make(map[string]any, len(row))
+	for column, value := range row {
+		if value == nil {
+			value = nullValue
+		}
+		converted[column] = value
+	}
+	return converted
+}
+
+func parseTemplate(command string) (*template.Template, error) {
+	t, err := template.New("liteargs").Delims(leftDelim, rightDelim).Funcs(templateFuncs).Parse(command)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
+	return t, nil
+}
+
+// renderFilter renders filter expression as a template over KEY=VALUE vars and environment available as .env
+func renderFilter(filter string, vars []string, environ []string) (string, error) {
+	if !strings.Contains(filter, cmp.Or(leftDelim, "{{")) {
+		return filter, nil
+	}
+	env := make(map[string]string, len(environ))
+	for _, pair := range environ {
+		key, value, _ := strings.Cut(pair, "=")
+		env[key] = value
+	}
+	data := map[string]any{"env": env}
+	for _, pair := range vars {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("filter var must be KEY=VALUE pair, got: '%v'", pair)
+		}
+		data[key] = value
+	}
+	t, err := parseTemplate(filter)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err = t.Option("missingkey=error").Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render filter template: %w", err)
+	}
+	return rendered.String(), nil
+}
+
+func render(command string, rows []map[string]any) ([]string, error) {
+	t, err := parseTemplate(command)
+	if err != nil {
+		return nil, err
+	}
 	buffer := make([]byte, 0, 1024)
 	writer := bytes.NewBuffer(buffer)
 	commands := make([]string, 0, len(rows))
 	for _, row := range rows {
-		err = t.Execute(writer, row)
+		err = t.Execute(writer, templateRow(row))
 		if err != nil {
 			return nil, fmt.Errorf("failed to render template: %w", err)
 		}
@@ -107,212 +321,2829 @@ func render(command string, rows []map[string]any) ([]string, error) {
 	return commands, nil
 }
 
-func run(ctx context.Context, shell string, command string) (bool, string, string) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd := exec.Command(shell, "-c", command)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// renderSkipping renders the command for every row individually and skips rows which failed to render
+func renderSkipping(command string, rows []map[string]any, pks []any) ([]map[string]any, []any, []string, error) {
+	t, err := parseTemplate(command)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var writer bytes.Buffer
+	renderedRows, renderedPks, commands := make([]map[string]any, 0, len(rows)), make([]any, 0, len(pks)), make([]string, 0, len(rows))
+	for i, row := range rows {
+		writer.Reset()
+		if err = t.Execute(&writer, templateRow(row)); err != nil {
+			warnLog("row skipped: rowid=%v, failed to render template: %v", pks[i], err)
+			continue
+		}
+		renderedRows, renderedPks, commands = append(renderedRows, row), append(renderedPks, pks[i]), append(commands, writer.String())
+	}
+	return renderedRows, renderedPks, commands, nil
+}
 
-	startTime := time.Now()
-	err := cmd.Start()
+// renderBatches renders single command for every batch of at most size consecutive rows available as .rows in the template
+func renderBatches(command string, rows []map[string]any, pks []any, size int) ([]string, [][]any, []map[string]any, error) {
+	t, err := parseTemplate(command)
 	if err != nil {
-		errorLog("failed to execute command: %v, err=%v", command, err)
-		return false, "", ""
+		return nil, nil, nil, err
+	}
+	var writer bytes.Buffer
+	commands, batches, heads := make([]string, 0), make([][]any, 0), make([]map[string]any, 0)
+	for start := 0; start < len(rows); start += size {
+		end := min(start+size, len(rows))
+		batchRows := make([]map[string]any, 0, end-start)
+		for _, row := range rows[start:end] {
+			batchRows = append(batchRows, templateRow(row))
+		}
+		writer.Reset()
+		if err = t.Execute(&writer, map[string]any{"rows": batchRows}); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		commands, batches, heads = append(commands, writer.String()), append(batches, pks[start:end]), append(heads, rows[start])
+	}
+	return commands, batches, heads, nil
+}
+
+// dedupCommands collapses identical commands into single one covering primary keys of all rows which rendered it
+func dedupCommands(commands []string, rows []map[string]any, pks []any) ([]string, [][]any, []map[string]any) {
+	indices := make(map[string]int, len(commands))
+	distinct, batches, heads := make([]string, 0), make([][]any, 0), make([]map[string]any, 0)
+	for i, command := range commands {
+		if index, ok := indices[command]; ok {
+			batches[index] = append(batches[index], pks[i])
+			continue
+		}
+		indices[command] = len(distinct)
+		distinct, batches, heads = append(distinct, command), append(batches, []any{pks[i]}), append(heads, rows[i])
 	}
-	traceLog("command started: %v", command)
+	return distinct, batches, heads
+}
 
-	waitCh := make(chan error, 1)
-	go func() { waitCh <- cmd.Wait() }()
+// preview renders and prints commands for at most limit first rows (all rows if limit is not positive)
+func preview(w io.Writer, command string, rows []map[string]any, limit int) error {
+	t, err := parseTemplate(command)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if limit > 0 && i >= limit {
+			infoLog("shown %v commands out of %v", limit, len(rows))
+			break
+		}
+		if err = t.Execute(w, templateRow(row)); err != nil {
+			return fmt.Errorf("failed to render template: %w", err)
+		}
+		if _, err = fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	select {
-	case err = <-waitCh:
-		if err == nil {
-			okLog("command succeed: %v, elapsed=%v, stdout=%v", command, time.Since(startTime), stdout.String())
-			return true, stdout.String(), stderr.String()
+// previewCommands writes at most limit (if positive) of the already rendered commands one per line
+func previewCommands(w io.Writer, commands []string, limit int) error {
+	for i, command := range commands {
+		if limit > 0 && i >= limit {
+			infoLog("shown %v commands out of %v", limit, len(commands))
+			break
 		}
-		errorLog("command failed: %v, err=%v", command, err)
-	case <-ctx.Done():
-		traceLog("command interrupted: %v", command)
-		err = cmd.Process.Signal(syscall.SIGINT)
+		if _, err := fmt.Fprintln(w, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateFilter keeps only rows for which predicate template renders to true
+func templateFilter(predicate string, rows []map[string]any) ([]map[string]any, []any, error) {
+	results, err := render(predicate, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	filteredRows := make([]map[string]any, 0, len(rows))
+	filteredPks := make([]any, 0, len(rows))
+	for i, result := range results {
+		keep, err := strconv.ParseBool(strings.TrimSpace(result))
 		if err != nil {
-			traceLog("command interruption failed: %v, err=%v", command, err)
+			return nil, nil, fmt.Errorf("template filter must render to boolean value, got '%v' for rowid=%v", result, rows[i]["rowid"])
+		}
+		if keep {
+			filteredRows = append(filteredRows, rows[i])
+			filteredPks = append(filteredPks, rows[i]["rowid"])
 		}
-		_ = cmd.Process.Kill()
 	}
-	return false, stdout.String(), stderr.String()
+	return filteredRows, filteredPks, nil
 }
 
-func main() {
-	var (
-		execParallelism int
-		execTake        int
-		execFilter      string
-		execOrder       string
-		execShell       string
-		execShow        bool
-	)
-	var execCmd = &cobra.Command{
-		Use:   "exec [state.db] [command]",
-		Short: "Execute a command with the state database",
-		Args:  cobra.ExactArgs(2),
-		Run: func(cmd *cobra.Command, args []string) {
-			db, err := NewLiteArgsDb(args[0])
-			if err != nil {
-				fatalLog("%v", err)
-			}
-			rows, pks, err := db.Filter(LiteArgsDbFilter{
-				Take:   execTake,
-				Filter: execFilter,
-				Order:  execOrder,
-			})
-			if err != nil {
-				fatalLog("%v", err)
-			}
-			commands, err := render(args[1], rows)
-			if err != nil {
-				fatalLog("%v", err)
+const (
+	orderByAttempts = "attempts DESC"
+	orderByDuration = "last_duration_ms DESC NULLS LAST"
+)
+
+// renderShells renders shell template for every row (or returns nil if it doesn't depend on the row)
+func renderShells(shell string, rows []map[string]any, validate bool) ([]string, error) {
+	shells, err := render(shell, rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render shell: %w", err)
+	}
+	if !slices.ContainsFunc(shells, func(rendered string) bool { return rendered != shell }) {
+		return nil, nil
+	}
+	if validate {
+		checked := make(map[string]struct{})
+		for _, rendered := range shells {
+			if _, ok := checked[rendered]; ok {
+				continue
 			}
-			if execShow {
-				for _, command := range commands {
-					fmt.Println(command)
-				}
-				return
+			if _, err = exec.LookPath(rendered); err != nil {
+				return nil, fmt.Errorf("rendered shell '%v' isn't executable: %w", rendered, err)
 			}
+			checked[rendered] = struct{}{}
+		}
+	}
+	return shells, nil
+}
 
-			var group errgroup.Group
-			group.SetLimit(execParallelism)
+var timestampLayouts = []string{loadedAtLayout, time.DateTime, time.RFC3339Nano, time.DateOnly}
 
-			startTime := time.Now()
-			succeedCnt, failedCnt := int32(0), int32(0)
-			for i, command := range commands {
-				group.Go(func() error {
-					succeed, stdout, stderr := run(cmd.Context(), execShell, command)
-					err := db.Update(pks[i], succeed, stdout, stderr, time.Now())
-					if err != nil {
-						traceLog("%v", err)
-					}
-					if succeed && err == nil {
-						atomic.AddInt32(&succeedCnt, 1)
-					} else {
-						atomic.AddInt32(&failedCnt, 1)
-					}
-					return nil
-				})
-			}
-			_ = group.Wait()
-			infoLog("succeed: %v, failed: %v, elapsed=%v", succeedCnt, failedCnt, time.Since(startTime))
-		},
+// parseTimestamp parses timestamp in one of the supported layouts using local timezone when it isn't specified
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
 	}
-	execCmd.Flags().IntVarP(&execParallelism, "parallelism", "p", 1, "maximum execution parallelism")
-	execCmd.Flags().IntVarP(&execTake, "take", "t", 0, "execute command only for first element; -1 removes any limits")
-	execCmd.Flags().StringVar(&execFilter, "filter", "", "arbitrary SQL filter")
-	execCmd.Flags().StringVar(&execOrder, "order", "", "arbitrary SQL filter")
-	execCmd.Flags().StringVar(&execShell, "shell", "sh", "shell for commands execution")
-	execCmd.Flags().BoolVar(&execShow, "show", false, "show commands but do not execute them")
+	return time.Time{}, fmt.Errorf("failed to parse timestamp '%v': expected one of layouts %v", value, timestampLayouts)
+}
 
-	var inspectCmd = &cobra.Command{
-		Use:   "shell [state.db]",
-		Short: "Shell into the liteargs state database",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			emptyWelcome := ""
-			err := shell.RunShell(shell.ShellConfig{
-				DbUri:          args[0],
-				InF:            os.Stdin,
-				OutF:           os.Stdout,
-				ErrF:           os.Stderr,
-				WelcomeMessage: &emptyWelcome,
-			})
-			if err != nil {
-				fatalLog("failed to exit shell: %v", err)
-			}
-		},
+// backup snapshots local state db file into the timestamped file next to it and returns path of the backup
+func backup(db *LiteArgsDb, file string, t time.Time) (string, error) {
+	if file == ":memory:" || strings.Contains(file, "://") {
+		warnLog("backup skipped: state db %v isn't a local file", file)
+		return "", nil
+	}
+	path := fmt.Sprintf("%v.backup-%v", file, t.Format("20060102-150405"))
+	if err := db.Backup(path); err != nil {
+		return "", err
 	}
+	return path, nil
+}
 
-	var resetCmd = &cobra.Command{
-		Use:   "reset [state.db]",
-		Short: "Reset the state database",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			db, err := NewLiteArgsDb(args[0])
-			if err != nil {
-				fatalLog("%v", err)
+// confirm asks user to confirm execution of the amount of commands above the threshold
+func confirm(in io.Reader, w io.Writer, count int, threshold int) bool {
+	if threshold <= 0 || count <= threshold {
+		return true
+	}
+	return ask(in, w, fmt.Sprintf("going to execute %v commands (more than %v)", count, threshold))
+}
+
+// ask writes the question to w and returns true if user answered yes
+func ask(in io.Reader, w io.Writer, question string) bool {
+	_, _ = fmt.Fprintf(w, "%v%v, continue? [y/N] ", warnHeader.Sprintf("warn : "), question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// checkRowIds reports duplicate and nonexistent requested rowids and fails on them in strict mode
+func checkRowIds(db *LiteArgsDb, rowIds []int64, strict bool) error {
+	seen := make(map[int64]struct{}, len(rowIds))
+	duplicates := make([]int64, 0)
+	for _, rowId := range rowIds {
+		if _, ok := seen[rowId]; ok {
+			duplicates = append(duplicates, rowId)
+		}
+		seen[rowId] = struct{}{}
+	}
+	missing, err := db.Missing(rowIds)
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		warnLog("duplicate rowids requested: %v", duplicates)
+	}
+	if len(missing) > 0 {
+		warnLog("requested rowids not found: %v", missing)
+	}
+	if strict && (len(duplicates) > 0 || len(missing) > 0) {
+		return fmt.Errorf("invalid rowids requested: duplicates=%v, missing=%v", duplicates, missing)
+	}
+	return nil
+}
+
+// explain writes SQL query composed for the filter together with its query plan
+func explain(w io.Writer, db *LiteArgsDb, filter LiteArgsDbFilter) error {
+	plan, err := db.Explain(filter)
+	if err != nil {
+		return err
+	}
+	query, args := db.FilterQuery(filter)
+	_, _ = fmt.Fprintf(w, "%v%v\n", traceHeader.Sprintf("query: "), query)
+	if len(args) > 0 {
+		_, _ = fmt.Fprintf(w, "%v%v\n", traceHeader.Sprintf("args : "), args)
+	}
+	for _, detail := range plan {
+		_, _ = fmt.Fprintf(w, "%v%v\n", traceHeader.Sprintf("plan : "), detail)
+	}
+	return nil
+}
+
+// tail polls the state db and writes failures which happened after the start until the context is cancelled
+func tail(ctx context.Context, w io.Writer, db *LiteArgsDb, interval time.Duration) error {
+	type attempt struct {
+		primaryKey int64
+		attempts   int
+	}
+	since := time.Now()
+	seen := make(map[attempt]struct{})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		failures, err := db.Failures(since)
+		if err != nil {
+			return err
+		}
+		for _, failure := range failures {
+			key := attempt{primaryKey: failure.PrimaryKey, attempts: failure.Attempts}
+			if _, ok := seen[key]; ok {
+				continue
 			}
-			if err = db.Reset(); err != nil {
-				fatalLog("%v", err)
+			seen[key] = struct{}{}
+			_, _ = fmt.Fprintf(w, "%vrowid=%v, attempts=%v, dt=%v\n", errorHeader.Sprintf("failed: "), failure.PrimaryKey, failure.Attempts, failure.LastAttemptDt)
+			if failure.LastStderr != "" {
+				_, _ = fmt.Fprintln(w, strings.TrimRight(failure.LastStderr, "\n"))
 			}
-		},
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 	}
+}
 
-	var (
-		loadNoHeader bool
-		loadSep      string
-		loadInput    string
-	)
-	var loadCmd = &cobra.Command{
-		Use:   "load [state.db]",
-		Short: "Load the state database",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			db, err := NewLiteArgsDb(args[0])
-			if err != nil {
-				fatalLog("%v", err)
-			}
+// failingCommands writes at most limit commands of rows which failed within the run, so they can be reproduced manually
+func failingCommands(w io.Writer, db *LiteArgsDb, runId string, limit int) error {
+	failures, err := db.RunFailures(runId)
+	if err != nil {
+		return err
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	_, _ = fmt.Fprintf(w, "%vfirst %v of %v failed commands:\n", errorHeader.Sprintf("failed: "), min(limit, len(failures)), len(failures))
+	for _, failure := range failures[:min(limit, len(failures))] {
+		_, _ = fmt.Fprintf(w, "# rowid=%v, attempts=%v\n%v\n", failure.PrimaryKey, failure.Attempts, failure.LastCommand)
+	}
+	return nil
+}
 
-			reader := input(loadInput)
-			defer reader.Close()
+// writeRetryFile writes rowids of rows failed within the run one per line and returns amount of written rowids
+func writeRetryFile(path string, db *LiteArgsDb, runId string, withCommands bool) (int, error) {
+	failures, err := db.RunFailures(runId)
+	if err != nil {
+		return 0, err
+	}
+	var content strings.Builder
+	for _, failure := range failures {
+		if withCommands {
+			for _, line := range strings.Split(strings.TrimRight(failure.LastCommand, "\n"), "\n") {
+				content.WriteString("# " + line + "\n")
+			}
+		}
+		content.WriteString(strconv.FormatInt(failure.PrimaryKey, 10) + "\n")
+	}
+	if err = os.WriteFile(path, []byte(content.String()), 0o644); err != nil {
+		return 0, fmt.Errorf("failed to write retry file %v: %w", path, err)
+	}
+	return len(failures), nil
+}
 
-			csvReader := csv.NewReader(reader)
-			csvReader.Comma = separator(loadSep)
+// readRowIds reads rowids written one per line skipping empty lines and # comments
+func readRowIds(r io.Reader) ([]int64, error) {
+	rowIds := make([]int64, 0)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rowId, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rowid must be an integer, got: '%v' at line %v", text, line)
+		}
+		rowIds = append(rowIds, rowId)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rowids: %w", err)
+	}
+	return rowIds, nil
+}
 
-			var header []string
-			lineNumber, recordNumber := 0, 0
-			for {
-				lineNumber++
-				records, err := csvReader.Read()
-				if errors.Is(err, io.EOF) {
-					break
-				} else if err != nil {
-					fatalLog("failed to read csv line %v: err=%v", lineNumber, err)
-				}
+// exportValue converts raw value scanned from the state db to the printable representation
+func exportValue(value any) any {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	default:
+		return v
+	}
+}
 
-				if lineNumber == 1 && loadNoHeader {
-					header = make([]string, len(records))
-					for i := range header {
-						header[i] = fmt.Sprintf("arg%d", i)
-					}
-				} else if lineNumber == 1 {
-					header = records
-				}
+// csvOutput configures CSV export: separator, line endings and quoting of every field (not only ones which require it)
+type csvOutput struct {
+	sep         rune
+	crlf        bool
+	alwaysQuote bool
+}
 
-				if lineNumber == 1 {
-					err = db.Init(header)
-					if err != nil {
-						fatalLog("%v", err)
-					}
-					if !loadNoHeader {
-						continue
-					}
-				}
+// writeQuoted writes the record with every field quoted as encoding/csv only quotes fields when required
+func writeQuoted(w io.Writer, record []string, output csvOutput) error {
+	var line strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			line.WriteRune(output.sep)
+		}
+		line.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
+	}
+	if output.crlf {
+		line.WriteString("\r\n")
+	} else {
+		line.WriteString("\n")
+	}
+	_, err := io.WriteString(w, line.String())
+	return err
+}
 
-				recordNumber++
-				err = db.Insert(records)
-				if err != nil {
-					fatalLog("%v, line=%v", err, lineNumber)
-				}
-			}
-			infoLog("successfully loaded %v records", recordNumber)
-		},
+// exportRows selects rows for export together with the exported columns: all rows are selected unless pendingOnly is set
+func exportRows(db *LiteArgsDb, filter LiteArgsDbFilter, pendingOnly bool) ([]string, []map[string]any, error) {
+	if !pendingOnly {
+		filter.WhereRaw, filter.Filter = cmp.Or(filter.Filter, "1 = 1"), ""
+		filter.IncludeDisabled, filter.IncludeLocked = true, true
 	}
-	loadCmd.Flags().StringVarP(&loadInput, "input", "i", "", "input file with data")
-	loadCmd.Flags().StringVarP(&loadSep, "separator", "s", ",", "CSV separator")
-	loadCmd.Flags().BoolVar(&loadNoHeader, "no-header", false, "CSV have no header provided")
+	rows, _, err := db.Filter(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(db.Columns(), filter.StateColumns...), rows, nil
+}
 
-	var rootCmd = &cobra.Command{Use: "liteargs"}
-	rootCmd.AddCommand(execCmd, inspectCmd, resetCmd, loadCmd)
+func export(w io.Writer, format string, output csvOutput, columns []string, rows []map[string]any) error {
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Comma = output.sep
+		csvWriter.UseCRLF = output.crlf
+		write := csvWriter.Write
+		if output.alwaysQuote {
+			write = func(record []string) error { return writeQuoted(w, record, output) }
+		}
+		if err := write(columns); err != nil {
+			return fmt.Errorf("failed to write csv header: %w", err)
+		}
+		record := make([]string, len(columns))
+		for _, row := range rows {
+			for i, column := range columns {
+				record[i] = fmt.Sprint(exportValue(row[column]))
+			}
+			if err := write(record); err != nil {
+				return fmt.Errorf("failed to write csv record: %w", err)
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "json", "jsonl":
+		objects := make([]map[string]any, len(rows))
+		for i, row := range rows {
+			objects[i] = make(map[string]any, len(row))
+			for column, value := range row {
+				objects[i][column] = exportValue(value)
+			}
+		}
+		encoder := json.NewEncoder(w)
+		if format == "json" {
+			if err := encoder.Encode(objects); err != nil {
+				return fmt.Errorf("failed to write json: %w", err)
+			}
+			return nil
+		}
+		for _, object := range objects {
+			if err := encoder.Encode(object); err != nil {
+				return fmt.Errorf("failed to write json line: %w", err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: '%v'", format)
+	}
+}
+
+var versionDependencies = []string{
+	"github.com/tursodatabase/libsql-client-go",
+	"github.com/libsql/libsql-shell-go",
+	"github.com/mattn/go-sqlite3",
+}
+
+// version writes build information of the binary and optional stats of the state db
+func version(w io.Writer, db *LiteArgsDb) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Errorf("failed to read build info")
+	}
+	binaryVersion := info.Main.Version
+	if binaryVersion == "" {
+		binaryVersion = "unknown"
+	}
+	_, _ = fmt.Fprintf(w, "liteargs: %v\n", binaryVersion)
+	_, _ = fmt.Fprintf(w, "go: %v\n", info.GoVersion)
+	for _, dependency := range info.Deps {
+		if slices.Contains(versionDependencies, dependency.Path) {
+			_, _ = fmt.Fprintf(w, "%v: %v\n", dependency.Path, dependency.Version)
+		}
+	}
+	if db == nil {
+		return nil
+	}
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "sqlite: %v\n", stats.SqliteVersion)
+	_, _ = fmt.Fprintf(w, "columns: %v\n", strings.Join(db.Columns(), ", "))
+	_, _ = fmt.Fprintf(w, "rows: total=%v, succeed=%v, failed=%v, pending=%v\n", stats.Total, stats.Succeed, stats.Failed, stats.Pending)
+	return nil
+}
+
+// recordLoadMeta stores provenance of the load in the state db
+func recordLoadMeta(db *LiteArgsDb, command []string, sep string, source string, t time.Time) error {
+	if source == "" {
+		source = "stdin"
+	}
+	for _, entry := range []LiteArgsDbMeta{
+		{Key: "load_command", Value: strings.Join(command, " ")},
+		{Key: "load_separator", Value: sep},
+		{Key: "load_source", Value: source},
+		{Key: "load_dt", Value: t.Format(time.DateTime)},
+	} {
+		if err := db.SetMeta(entry.Key, entry.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printRow writes all columns of the row either as human-readable lines or as a single JSON object
+func printRow(w io.Writer, columns []string, row map[string]any, asJson bool) error {
+	if asJson {
+		object := make(map[string]any, len(row))
+		for column, value := range row {
+			object[column] = exportValue(value)
+		}
+		if err := json.NewEncoder(w).Encode(object); err != nil {
+			return fmt.Errorf("failed to write json: %w", err)
+		}
+		return nil
+	}
+	for _, column := range columns {
+		_, _ = fmt.Fprintf(w, "%v %v\n", infoHeader.Sprintf("%v:", column), exportValue(row[column]))
+	}
+	return nil
+}
+
+// status writes overall stats of the state db and histogram of exit codes for failed (and optionally succeed) rows
+func status(w io.Writer, db *LiteArgsDb, countSuccess bool) error {
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "total: %v, succeed: %v, failed: %v, pending: %v\n", stats.Total, stats.Succeed, stats.Failed, stats.Pending)
+	groups := []bool{false}
+	if countSuccess {
+		groups = append(groups, true)
+	}
+	for _, succeed := range groups {
+		histogram, err := db.ExitCodes(succeed)
+		if err != nil {
+			return err
+		}
+		title := "failed"
+		if succeed {
+			title = "succeed"
+		}
+		for _, count := range histogram {
+			exitCode := "unknown"
+			if count.ExitCode != nil {
+				exitCode = strconv.FormatInt(*count.ExitCode, 10)
+			}
+			_, _ = fmt.Fprintf(w, "%v exit %v: %v\n", title, exitCode, count.Count)
+		}
+	}
+	return nil
+}
+
+// distinct writes distinct values of the column with amount of rows having them, most frequent first
+func distinct(w io.Writer, db *LiteArgsDb, column string) error {
+	counts, err := db.Distinct(column)
+	if err != nil {
+		return err
+	}
+	for _, count := range counts {
+		value := exportValue(count.Value)
+		if count.Value == nil {
+			value = "NULL"
+		}
+		_, _ = fmt.Fprintf(w, "%v: %v\n", value, count.Count)
+	}
+	return nil
+}
+
+type loadOptions struct {
+	noHeader bool
+	sep      rune
+	// maxLineSize fails the load on the input line longer than the limit if it is positive
+	maxLineSize int
+	// lazyQuotes and trimLeadingSpace relax CSV parsing (see csvDialect)
+	lazyQuotes       bool
+	trimLeadingSpace bool
+	useColumns       []string
+	maxRows          int
+	emptyNull        bool
+	// resume continues previous load of the same seekable input from the recorded offset
+	resume bool
+	// noInit appends records to the existing table without altering its schema
+	noInit bool
+	// format is one of csv, tsv, json or jsonl; csv is used when not set
+	format string
+	// withUUID adds uuid column with random UUID generated for every record
+	withUUID bool
+	// withSeq adds seq column with integer sequence number of every record continuing the greatest existing one
+	withSeq bool
+	// parallelism inserts batches concurrently by given amount of workers; can't be used together with resume
+	parallelism int
+	// tag is stored in the liteargs_tag column of every loaded row
+	tag string
+	// strictSchema aborts the load if existing table columns differ from the header in names or order
+	strictSchema bool
+	// typesDirective is prefix of the optional CSV line before the header which declares column types (e.g. "#types:")
+	typesDirective string
+}
+
+const loadBatchSize = 1000
+
+// inputFingerprint identifies the input file by its absolute path, size and modification time
+func inputFingerprint(reader io.Reader) (string, error) {
+	file, ok := reader.(*os.File)
+	if !ok {
+		return "", nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat input file: %w", err)
+	}
+	path, err := filepath.Abs(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve input file path: %w", err)
+	}
+	return fmt.Sprintf("%v size=%v mtime=%v", path, info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano)), nil
+}
+
+// loadProgress returns byte offset and line number of the input processed by the previous load
+func loadProgress(db *LiteArgsDb) (int64, int, error) {
+	offset, ok, err := db.GetMeta("load_offset")
+	if err != nil || !ok {
+		return 0, 0, err
+	}
+	line, _, err := db.GetMeta("load_line")
+	if err != nil {
+		return 0, 0, err
+	}
+	parsedOffset, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse load offset '%v': %w", offset, err)
+	}
+	parsedLine, err := strconv.Atoi(line)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse load line '%v': %w", line, err)
+	}
+	return parsedOffset, parsedLine, nil
+}
+
+// recordEnv returns JSON object with values of the keys from KEY=VALUE pairs where later pairs override earlier ones
+func recordEnv(keys []string, environ []string) (string, error) {
+	values := make(map[string]*string, len(keys))
+	for _, key := range keys {
+		values[key] = nil
+	}
+	for _, pair := range environ {
+		key, value, _ := strings.Cut(pair, "=")
+		if _, ok := values[key]; ok {
+			values[key] = &value
+		}
+	}
+	recorded, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode recorded env: %w", err)
+	}
+	return string(recorded), nil
+}
+
+// parseIn parses column=value1,value2 selections where values are CSV fields, so they can be quoted to contain commas
+func parseIn(selections []string, columns []string) ([]LiteArgsDbIn, error) {
+	in := make([]LiteArgsDbIn, 0, len(selections))
+	for _, selection := range selections {
+		column, list, ok := strings.Cut(selection, "=")
+		if !ok || list == "" {
+			return nil, fmt.Errorf("invalid in selection '%v': expected column=value1,value2", selection)
+		}
+		if !slices.Contains(columns, column) {
+			return nil, fmt.Errorf("in column '%v' not found in columns: %v", column, columns)
+		}
+		values, err := csv.NewReader(strings.NewReader(list)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse values of in selection '%v': %w", selection, err)
+		}
+		in = append(in, LiteArgsDbIn{Column: column, Values: values})
+	}
+	return in, nil
+}
+
+// rowTimeouts returns timeout for every row from the column (Go duration or number of seconds) or fallback when it is empty
+func rowTimeouts(rows []map[string]any, column string, fallback time.Duration) ([]time.Duration, error) {
+	timeouts := make([]time.Duration, len(rows))
+	for i, row := range rows {
+		value := strings.TrimSpace(fmt.Sprint(exportValue(row[column])))
+		if column == "" || value == "" {
+			timeouts[i] = fallback
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			timeouts[i] = time.Duration(seconds * float64(time.Second))
+			continue
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%v' in column %v for rowid=%v: %w", value, column, row["rowid"], err)
+		}
+		timeouts[i] = timeout
+	}
+	return timeouts, nil
+}
+
+// parseParallelism resolves parallelism given as a positive number, auto (amount of CPUs) or multiple of CPUs (like 2x)
+func parseParallelism(value string) (int, error) {
+	if value == "auto" {
+		return runtime.NumCPU(), nil
+	}
+	multiple, cpus := strings.CutSuffix(value, "x")
+	parallelism, err := strconv.Atoi(multiple)
+	if err != nil || parallelism <= 0 {
+		return 0, fmt.Errorf("invalid parallelism '%v': expected positive number, auto or multiple of CPUs like 2x", value)
+	}
+	if cpus {
+		parallelism *= runtime.NumCPU()
+	}
+	return parallelism, nil
+}
+
+const execWatermarkKey = "exec_watermark"
+
+// execWatermark returns max rowid recorded by the previous exec run or zero if there were no runs
+func execWatermark(db *LiteArgsDb) (int64, error) {
+	watermark, ok, err := db.GetMeta(execWatermarkKey)
+	if err != nil || !ok {
+		return 0, err
+	}
+	parsed, err := strconv.ParseInt(watermark, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse exec watermark '%v': %w", watermark, err)
+	}
+	return parsed, nil
+}
+
+// advanceExecWatermark records the rowid as the exec watermark unless the previous watermark is already greater
+func advanceExecWatermark(db *LiteArgsDb, rowId int64) error {
+	watermark, err := execWatermark(db)
+	if err != nil || rowId <= watermark {
+		return err
+	}
+	return db.SetMeta(execWatermarkKey, strconv.FormatInt(rowId, 10))
+}
+
+// maxRowId returns the greatest of the given primary keys or zero if there are none
+func maxRowId(pks []any) int64 {
+	var result int64
+	for _, pk := range pks {
+		if rowId, ok := pk.(int64); ok {
+			result = max(result, rowId)
+		}
+	}
+	return result
+}
+
+// newUUID generates random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// recordValues converts CSV record to the values for insertion; values marked in nulls (if any) are inserted as NULL
+func recordValues(records []string, nulls []bool, emptyNull bool) []any {
+	values := anyArray(records)
+	for i, record := range records {
+		if (emptyNull && record == "") || (nulls != nil && nulls[i]) {
+			values[i] = nil
+		}
+	}
+	return values
+}
+
+// projection returns indices of the selected columns in the header
+func projection(header []string, useColumns []string) ([]int, error) {
+	indices := make([]int, 0, len(useColumns))
+	for _, column := range useColumns {
+		index := slices.Index(header, column)
+		if index == -1 {
+			return nil, fmt.Errorf("column '%v' not found in header: %v", column, header)
+		}
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+func project[T any](records []T, indices []int) []T {
+	projected := make([]T, len(indices))
+	for i, index := range indices {
+		projected[i] = records[index]
+	}
+	return projected
+}
+
+// existingProjection returns indices which reorder records to the columns order of the existing table
+func existingProjection(db *LiteArgsDb, header []string, indices []int) ([]int, error) {
+	existing := db.Columns()[1:]
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("can't load without init: %w", ErrNoTable)
+	}
+	if len(header) != len(existing) {
+		return nil, fmt.Errorf("header %v doesn't match existing columns %v", header, existing)
+	}
+	reordered, err := projection(header, existing)
+	if err != nil {
+		return nil, fmt.Errorf("header %v doesn't match existing columns %v", header, existing)
+	}
+	if indices == nil {
+		return reordered, nil
+	}
+	return project(indices, reordered), nil
+}
+
+// schemaDiff describes positions where header columns differ from the existing table columns
+func schemaDiff(existing []string, header []string) []string {
+	diff := make([]string, 0)
+	for i := 0; i < max(len(existing), len(header)); i++ {
+		tableColumn, headerColumn := "<none>", "<none>"
+		if i < len(existing) {
+			tableColumn = existing[i]
+		}
+		if i < len(header) {
+			headerColumn = header[i]
+		}
+		if tableColumn != headerColumn {
+			diff = append(diff, fmt.Sprintf("column %v: table has '%v', input has '%v'", i+1, tableColumn, headerColumn))
+		}
+	}
+	return diff
+}
+
+// load reads CSV or JSON records from the reader into the state db and returns amount of loaded records
+func load(db *LiteArgsDb, reader io.Reader, options loadOptions) (loadedNumber int, err error) {
+	var resumeOffset int64
+	var resumeLine int
+	if options.resume && options.parallelism > 1 {
+		return 0, fmt.Errorf("resume can't be used together with parallel load")
+	}
+	fingerprint, err := inputFingerprint(reader)
+	if err != nil {
+		return 0, err
+	}
+	if options.resume {
+		if _, ok := reader.(io.Seeker); !ok {
+			return 0, fmt.Errorf("resume requires seekable input file")
+		}
+		resumeOffset, resumeLine, err = loadProgress(db)
+		if err != nil {
+			return 0, err
+		}
+		if resumeOffset > 0 {
+			recorded, ok, err := db.GetMeta("load_fingerprint")
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				warnLog("input of the interrupted load wasn't recorded, resuming without checking that the input is the same")
+			} else if recorded != fingerprint {
+				return 0, fmt.Errorf("input doesn't match the interrupted load: recorded '%v', got '%v'", recorded, fingerprint)
+			}
+		}
+	}
+
+	format := options.format
+	if format == "" {
+		format = "csv"
+	}
+	if (format == "json" || format == "jsonl") && options.noHeader {
+		return 0, fmt.Errorf("%v input always has a header", format)
+	}
+	if format == "nul" {
+		// NUL-separated input is a plain list of values without a header
+		options.noHeader = true
+	}
+	if format == "json" && resumeOffset > 0 {
+		return 0, fmt.Errorf("resume isn't supported for json input, use jsonl instead")
+	}
+	readerDialect := csvDialect{sep: options.sep, lazyQuotes: options.lazyQuotes, trimLeadingSpace: options.trimLeadingSpace}
+	limited := func(line int) io.Reader {
+		if options.maxLineSize <= 0 {
+			return reader
+		}
+		return &lineLimitReader{reader: reader, limit: options.maxLineSize, line: line}
+	}
+	recordReader := newRecordReader(format, limited(1), readerDialect, nil)
+
+	var header []string
+	var indices []int
+	// headerLine is the line with the header which follows the types directive if it is present
+	headerLine := 1
+	var types map[string]string
+	var readerOffset, processedOffset int64
+	batch, batchLines := make([][]any, 0, loadBatchSize), make([]int, 0, loadBatchSize)
+	lineNumber, recordNumber := 0, 0
+	// seq is the sequence number of the last record and seqIndex is position of the seq column in the table
+	var seq int64
+	var seqIndex int
+
+	// with parallelism batches are inserted concurrently in separate transactions without load progress
+	var group *errgroup.Group
+	var groupCtx context.Context
+	var parallelLoaded atomic.Int64
+	if options.parallelism > 1 {
+		group, groupCtx = errgroup.WithContext(context.Background())
+		group.SetLimit(options.parallelism)
+		defer func() {
+			if waitErr := group.Wait(); err == nil {
+				err = waitErr
+			}
+			loadedNumber += int(parallelLoaded.Load())
+		}()
+	}
+	flush := func() error {
+		rows, lines := batch, batchLines
+		var progress []LiteArgsDbMeta
+		if group == nil {
+			progress = []LiteArgsDbMeta{
+				{Key: "load_offset", Value: strconv.FormatInt(processedOffset, 10)},
+				{Key: "load_line", Value: strconv.Itoa(lines[len(lines)-1])},
+				{Key: "load_fingerprint", Value: fingerprint},
+			}
+		}
+		insert := func() error {
+			failed, err := db.InsertBatch(rows, options.tag, progress)
+			if err != nil && failed < len(lines) {
+				return fmt.Errorf("%w, line=%v", err, lines[failed])
+			} else if err != nil {
+				return err
+			}
+			return nil
+		}
+		if group == nil {
+			if err := insert(); err != nil {
+				return err
+			}
+			loadedNumber += len(rows)
+			batch, batchLines = batch[:0], batchLines[:0]
+			return nil
+		}
+		if groupCtx.Err() != nil {
+			return group.Wait()
+		}
+		group.Go(func() error {
+			if err := insert(); err != nil {
+				return err
+			}
+			parallelLoaded.Add(int64(len(rows)))
+			return nil
+		})
+		batch, batchLines = make([][]any, 0, loadBatchSize), make([]int, 0, loadBatchSize)
+		return nil
+	}
+	for {
+		lineNumber++
+		records, err := recordReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return loadedNumber, fmt.Errorf("failed to read %v line %v: err=%w", format, lineNumber, err)
+		}
+
+		if lineNumber == 1 && options.typesDirective != "" && len(records) > 0 && strings.HasPrefix(records[0], options.typesDirective) {
+			if types, err = parseTypesDirective(options.typesDirective, records); err != nil {
+				return loadedNumber, err
+			}
+			if csvReader, ok := recordReader.(*csv.Reader); ok {
+				// amount of fields is fixed by the first record, so let the header define it instead of the directive
+				csvReader.FieldsPerRecord = 0
+			}
+			headerLine = 2
+			continue
+		}
+
+		if lineNumber == headerLine && options.noHeader {
+			header = make([]string, len(records))
+			for i := range header {
+				header[i] = fmt.Sprintf("arg%d", i)
+			}
+		} else if lineNumber == headerLine {
+			header = records
+		}
+
+		if lineNumber == headerLine {
+			inputHeader := header
+			for column := range types {
+				if !slices.Contains(inputHeader, column) {
+					return loadedNumber, fmt.Errorf("column %v from %v directive not found in header: %v", column, options.typesDirective, inputHeader)
+				}
+			}
+			useColumns := options.useColumns
+			if options.withUUID {
+				if slices.Contains(header, "uuid") {
+					return loadedNumber, fmt.Errorf("header already has uuid column: %v", header)
+				}
+				header = append(slices.Clone(header), "uuid")
+				if len(useColumns) > 0 {
+					useColumns = append(slices.Clone(useColumns), "uuid")
+				}
+			}
+			if options.withSeq {
+				if slices.Contains(header, "seq") {
+					return loadedNumber, fmt.Errorf("header already has seq column: %v", header)
+				}
+				header = append(slices.Clone(header), "seq")
+				if len(useColumns) > 0 {
+					useColumns = append(slices.Clone(useColumns), "seq")
+				}
+			}
+			if len(useColumns) > 0 {
+				indices, err = projection(header, useColumns)
+				if err != nil {
+					return loadedNumber, err
+				}
+				header = project(header, indices)
+			}
+			if existing := db.Columns()[1:]; options.strictSchema && len(existing) > 0 {
+				if diff := schemaDiff(existing, header); len(diff) > 0 {
+					return loadedNumber, fmt.Errorf("header doesn't match existing table schema:\n%v", strings.Join(diff, "\n"))
+				}
+			}
+			if options.noInit {
+				indices, err = existingProjection(db, header, indices)
+			} else {
+				err = db.InitTyped(header, types)
+			}
+			if err != nil {
+				return loadedNumber, err
+			}
+			if options.withSeq {
+				seqIndex = slices.Index(db.Columns()[1:], "seq")
+				if seq, err = db.MaxInt("seq"); err != nil {
+					return loadedNumber, err
+				}
+			}
+			if resumeOffset > 0 {
+				if _, err = reader.(io.Seeker).Seek(resumeOffset, io.SeekStart); err != nil {
+					return loadedNumber, fmt.Errorf("failed to seek input to offset %v: %w", resumeOffset, err)
+				}
+				infoLog("resuming load from line %v, offset=%v", resumeLine+1, resumeOffset)
+				recordReader = newRecordReader(format, limited(resumeLine+1), readerDialect, inputHeader)
+				readerOffset, lineNumber = resumeOffset, resumeLine
+				continue
+			}
+			if !options.noHeader {
+				continue
+			}
+		}
+
+		if options.maxRows > 0 && recordNumber == options.maxRows {
+			warnLog("loading stopped at line %v: reached maximum of %v rows", lineNumber, options.maxRows)
+			break
+		}
+		var nulls []bool
+		if nullReader, ok := recordReader.(nullRecordReader); ok && nullReader.Nulls() != nil {
+			nulls = slices.Clone(nullReader.Nulls())
+		}
+		if options.withUUID {
+			records = append(records, newUUID())
+		}
+		if options.withSeq {
+			records = append(records, "")
+		}
+		if nulls != nil {
+			nulls = append(nulls, make([]bool, len(records)-len(nulls))...)
+		}
+		if indices != nil {
+			records = project(records, indices)
+			if nulls != nil {
+				nulls = project(nulls, indices)
+			}
+		}
+		recordNumber++
+		processedOffset = readerOffset + recordReader.InputOffset()
+		values := recordValues(records, nulls, options.emptyNull)
+		if options.withSeq {
+			seq++
+			values[seqIndex] = seq
+		}
+		batch = append(batch, values)
+		batchLines = append(batchLines, lineNumber)
+		if len(batch) == loadBatchSize {
+			if err = flush(); err != nil {
+				return loadedNumber, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return loadedNumber, err
+		}
+	}
+	return loadedNumber, nil
+}
+
+// successCriteria overrides default success determination (zero exit code) of the command
+type successCriteria struct {
+	// exitCodes lists exit codes treated as success
+	exitCodes []int
+	// stdoutRegex must match stdout of the successful command
+	stdoutRegex *regexp.Regexp
+	// failOnStderr treats any stderr output as failure
+	failOnStderr bool
+}
+
+func (c successCriteria) check(result CommandResult) bool {
+	if result.ExitCode == -1 {
+		return false
+	}
+	succeed := result.Succeed
+	if len(c.exitCodes) > 0 {
+		succeed = slices.Contains(c.exitCodes, result.ExitCode)
+	}
+	if c.stdoutRegex != nil {
+		succeed = succeed && c.stdoutRegex.MatchString(result.Stdout)
+	}
+	if c.failOnStderr {
+		succeed = succeed && result.Stderr == ""
+	}
+	return succeed
+}
+
+// parseNumber parses trimmed output as an integer or a float
+func parseNumber(output string) (any, error) {
+	output = strings.TrimSpace(output)
+	if integer, err := strconv.ParseInt(output, 10, 64); err == nil {
+		return integer, nil
+	}
+	float, err := strconv.ParseFloat(output, 64)
+	if err != nil {
+		return nil, fmt.Errorf("output isn't a number: '%v'", output)
+	}
+	return float, nil
+}
+
+// parseCapturedJson parses output as a flat JSON object; nested objects and arrays are kept as JSON text
+func parseCapturedJson(output string) (map[string]any, error) {
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return nil, fmt.Errorf("output isn't a JSON object: '%v'", strings.TrimSpace(output))
+	}
+	captured := make(map[string]any, len(object))
+	for key, value := range object {
+		switch v := value.(type) {
+		case json.Number:
+			if integer, err := v.Int64(); err == nil {
+				value = integer
+			} else if float, err := v.Float64(); err == nil {
+				value = float
+			} else {
+				value = v.String()
+			}
+		case map[string]any, []any:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode captured value of %v: %w", key, err)
+			}
+			value = string(encoded)
+		}
+		captured[key] = value
+	}
+	return captured, nil
+}
+
+// lastLines keeps only last n lines of the output prefixed with the truncation note
+func lastLines(output string, n int) string {
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return output
+	}
+	truncated := len(lines) - n
+	return fmt.Sprintf("[truncated %v lines]\n%v", truncated, strings.Join(lines[truncated:], ""))
+}
+
+// emptyCommands returns primary keys of rows which rendered to the empty command
+func emptyCommands(pks []any, commands []string) []any {
+	empty := make([]any, 0)
+	for i, command := range commands {
+		if strings.TrimSpace(command) == "" {
+			empty = append(empty, pks[i])
+		}
+	}
+	return empty
+}
+
+type execOptions struct {
+	parallelism int
+	shell       string
+	// executor runs the commands; LocalExecutor is used when not set
+	executor Executor
+	// executors overrides executor for every command individually when set
+	executors []Executor
+	// groups assigns every command to a group, commands of the same group are executed with at most groupParallelism concurrency
+	groups           []string
+	groupParallelism int
+	// batches lists primary keys of all rows covered by every command when commands are rendered for batches of rows
+	// pks (and rows) then contain only the first row of every batch while the result is recorded for all rows of the batch
+	batches [][]any
+	// timeouts limits execution time of every command individually when set (zero means no limit)
+	timeouts []time.Duration
+	// shells overrides shell for every command individually when set
+	shells   []string
+	noUpdate bool
+	// results receives JSON line for every completed command when set
+	results io.Writer
+	// captureNumber stores stdout parsed as a number in the result_value column
+	captureNumber bool
+	// captureJson stores fields of stdout parsed as JSON object into the same-named columns
+	// captureJsonGrow adds columns for the fields missing from the table
+	captureJson     bool
+	captureJsonGrow bool
+	// runId is stored in the last_run_id column of every attempt
+	runId string
+	// lockOnSuccess locks succeed rows, so they aren't selected again without force
+	lockOnSuccess bool
+	// force records results of the locked rows
+	force bool
+	// recordedEnv is JSON object stored in the last_env column of every attempt when set
+	recordedEnv string
+	// collectResults keeps results of all completed commands in the execution summary
+	collectResults bool
+	// keepGoing continues execution even if results of some commands can't be recorded in the state db
+	keepGoing bool
+	// trimOutput strips trailing newlines from stdout and stderr before recording them
+	trimOutput bool
+	// keepHistory appends every attempt to the liteargs_attempts table
+	keepHistory bool
+	// maxOutputLines keeps only last N lines of stdout and stderr when positive
+	maxOutputLines int
+	// success overrides default success determination when set
+	success *successCriteria
+	// preExec and postExec are local commands executed once before and after the whole batch
+	// postExec is executed even if preExec or some commands failed
+	preExec  string
+	postExec string
+	// checkpointEvery forces WAL checkpoint after every N completed commands when the state db is in WAL mode
+	checkpointEvery int
+	// maxAttempts re-queues failed commands within the same execution until they are attempted maxAttempts times
+	// retries are delayed by retryBackoff doubled after every attempt and interleaved with not yet started commands
+	maxAttempts  int
+	retryBackoff time.Duration
+	// retryExitCodes limits retries to the commands failed with one of the exit codes when set
+	retryExitCodes []int
+	// outputTemplate is rendered with the row and the command result and written to the output for every completed command
+	outputTemplate *template.Template
+	output         io.Writer
+	// rows are rows of the commands used for the output template
+	rows []map[string]any
+	// metrics are updated with running and completed commands when set
+	metrics *execMetrics
+	// progress receives progress event as JSON line every progressInterval and after the last command when set
+	progress         io.Writer
+	progressInterval time.Duration
+}
+
+type execSummary struct {
+	succeed     int32
+	failed      int32
+	skipped     int32
+	dbErrors    int32
+	hookErrors  int32
+	checkpoints int32
+	retried     int32
+	// results of completed commands collected when execOptions.collectResults is set
+	results []emittedResult
+}
+
+type emittedResult struct {
+	RowId      any    `json:"rowid"`
+	Succeed    bool   `json:"succeed"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+}
+
+// progressEvent is machine-readable execution progress; completed counts succeed, failed and skipped rows
+type progressEvent struct {
+	Completed int32 `json:"completed"`
+	Total     int   `json:"total"`
+	Running   int32 `json:"running"`
+	Succeeded int32 `json:"succeeded"`
+	Failed    int32 `json:"failed"`
+	ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+// reportProgress writes current progress every interval until the returned function is called, which writes the final progress
+func reportProgress(w io.Writer, interval time.Duration, progress func() progressEvent) func() {
+	encoder := json.NewEncoder(w)
+	emit := func() {
+		if err := encoder.Encode(progress()); err != nil {
+			traceLog("failed to emit progress: %v", err)
+		}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				emit()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		emit()
+	}
+}
+
+// outputLine renders output template with the row columns extended by the command result fields
+func outputLine(t *template.Template, row map[string]any, primaryKey any, result CommandResult) (string, error) {
+	data := make(map[string]any, len(row)+6)
+	for column, value := range templateRow(row) {
+		data[column] = value
+	}
+	data["rowid"] = primaryKey
+	data["succeed"] = result.Succeed
+	data["exit_code"] = result.ExitCode
+	data["duration_ms"] = result.Duration.Milliseconds()
+	data["stdout"] = result.Stdout
+	data["stderr"] = result.Stderr
+	var line bytes.Buffer
+	if err := t.Execute(&line, data); err != nil {
+		return "", fmt.Errorf("failed to render output template: %w", err)
+	}
+	return line.String(), nil
+}
+
+var (
+	updateAttempts = 5
+	updateBackoff  = 100 * time.Millisecond
+)
+
+// dbErrorState marks rows which command results weren't recorded due to the state db error
+const dbErrorState = "db_error"
+
+// retry calls f while it fails with ErrLocked and attempts aren't exhausted, doubling the backoff between attempts
+func retry(ctx context.Context, attempts int, backoff time.Duration, f func() error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = f(); err == nil || !errors.Is(err, ErrLocked) || attempt == attempts {
+			return err
+		}
+		traceLog("attempt %v/%v failed, retrying in %v: %v", attempt, attempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+type summaryFile struct {
+	Succeed    int32           `json:"succeed"`
+	Failed     int32           `json:"failed"`
+	Skipped    int32           `json:"skipped"`
+	Retried    int32           `json:"retried"`
+	DbErrors   int32           `json:"db_errors"`
+	HookErrors int32           `json:"hook_errors"`
+	ElapsedMs  int64           `json:"elapsed_ms"`
+	Results    []emittedResult `json:"results,omitempty"`
+}
+
+// writeSummary writes execution summary together with collected results (if any) to the file as JSON
+func writeSummary(path string, summary execSummary, elapsed time.Duration) error {
+	data, err := json.MarshalIndent(summaryFile{
+		Succeed:    summary.succeed,
+		Failed:     summary.failed,
+		Skipped:    summary.skipped,
+		Retried:    summary.retried,
+		DbErrors:   summary.dbErrors,
+		HookErrors: summary.hookErrors,
+		ElapsedMs:  elapsed.Milliseconds(),
+		Results:    summary.results,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	if err = os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write summary file %v: %w", path, err)
+	}
+	return nil
+}
+
+// lowMemoryChunkSize is amount of rows selected at once in the --low-memory mode
+const lowMemoryChunkSize = 1000
+
+func (s *execSummary) add(other execSummary) {
+	s.succeed += other.succeed
+	s.failed += other.failed
+	s.skipped += other.skipped
+	s.dbErrors += other.dbErrors
+	s.hookErrors += other.hookErrors
+	s.checkpoints += other.checkpoints
+	s.retried += other.retried
+	s.results = append(s.results, other.results...)
+}
+
+// executeChunks selects rows by chunks of the given size paginated by rowid and runs every chunk before selecting the next one
+func executeChunks(ctx context.Context, db *LiteArgsDb, filter LiteArgsDbFilter, chunkSize int, run func(rows []map[string]any, pks []any) execSummary) (execSummary, error) {
+	var summary execSummary
+	remaining := filter.Take
+	filter.PreserveOrder = true
+	for ctx.Err() == nil {
+		filter.Take = chunkSize
+		if remaining > 0 {
+			filter.Take = min(chunkSize, remaining)
+		}
+		rows, pks, err := db.Filter(filter)
+		if err != nil {
+			return summary, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		chunk := run(rows, pks)
+		summary.add(chunk)
+		if chunk.dbErrors > 0 || len(rows) < filter.Take {
+			break
+		}
+		if remaining > 0 {
+			if remaining -= len(rows); remaining == 0 {
+				break
+			}
+		}
+		filter.AfterRowId = pks[len(pks)-1].(int64)
+	}
+	return summary, nil
+}
+
+// execute runs rendered commands with given parallelism and records their results in the state db
+func execute(ctx context.Context, db *LiteArgsDb, pks []any, commands []string, options execOptions) (summary execSummary) {
+	var group errgroup.Group
+	group.SetLimit(options.parallelism)
+
+	var resultsLock sync.Mutex
+	var resultsEncoder *json.Encoder
+	if options.results != nil {
+		resultsEncoder = json.NewEncoder(options.results)
+	}
+
+	executor := options.executor
+	if executor == nil {
+		executor = LocalExecutor{}
+	}
+
+	checkpointEvery := options.checkpointEvery
+	if checkpointEvery > 0 && !options.noUpdate {
+		mode, err := db.JournalMode()
+		if err != nil {
+			traceLog("%v", err)
+			checkpointEvery = 0
+		} else if mode != "wal" {
+			traceLog("periodic checkpoints disabled: state db journal mode is %v, not wal", mode)
+			checkpointEvery = 0
+		}
+	}
+
+	var aborted atomic.Bool
+	var completed atomic.Int32
+	if options.postExec != "" {
+		defer func() {
+			traceLog("running post-exec hook")
+			if !(LocalExecutor{}).Run(context.WithoutCancel(ctx), options.shell, options.postExec).Succeed {
+				summary.hookErrors++
+			}
+		}()
+	}
+	if options.preExec != "" {
+		traceLog("running pre-exec hook")
+		if !(LocalExecutor{}).Run(ctx, options.shell, options.preExec).Succeed {
+			errorLog("pre-exec hook failed: execution skipped")
+			summary.hookErrors++
+			return summary
+		}
+	}
+	var running atomic.Int32
+	if options.progress != nil {
+		total := len(pks)
+		if options.batches != nil {
+			total = 0
+			for _, batch := range options.batches {
+				total += len(batch)
+			}
+		}
+		startTime := time.Now()
+		defer reportProgress(options.progress, cmp.Or(options.progressInterval, time.Second), func() progressEvent {
+			succeeded, failed := atomic.LoadInt32(&summary.succeed), atomic.LoadInt32(&summary.failed)
+			return progressEvent{
+				Completed: succeeded + failed + atomic.LoadInt32(&summary.skipped),
+				Total:     total,
+				Running:   running.Load(),
+				Succeeded: succeeded,
+				Failed:    failed,
+				ElapsedMs: time.Since(startTime).Milliseconds(),
+			}
+		})()
+	}
+	semaphores := make(map[string]chan struct{})
+	if options.groups != nil && options.groupParallelism > 0 {
+		for _, name := range options.groups {
+			if _, ok := semaphores[name]; !ok {
+				semaphores[name] = make(chan struct{}, options.groupParallelism)
+			}
+		}
+	}
+
+	// inflight counts commands which are running, waiting for a slot or waiting for the retry backoff
+	var inflight sync.WaitGroup
+	var runCommand func(i int, attempt int, semaphore chan struct{})
+	// dispatch takes a slot of the command group before the global parallelism slot,
+	// so commands waiting for a busy group never occupy slots needed by other groups
+	dispatch := func(i int, attempt int) {
+		var semaphore chan struct{}
+		if options.groups != nil {
+			semaphore = semaphores[options.groups[i]]
+		}
+		if semaphore != nil {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				inflight.Done()
+				return
+			}
+		}
+		group.Go(func() error {
+			runCommand(i, attempt, semaphore)
+			return nil
+		})
+	}
+	runCommand = func(i int, attempt int, semaphore chan struct{}) {
+		defer inflight.Done()
+		release := func() {
+			if semaphore != nil {
+				<-semaphore
+				semaphore = nil
+			}
+		}
+		defer release()
+		command := commands[i]
+		if aborted.Load() || ctx.Err() != nil {
+			return
+		}
+		if strings.TrimSpace(command) == "" {
+			warnLog("skipped empty command: rowid=%v", pks[i])
+			atomic.AddInt32(&summary.skipped, 1)
+			return
+		}
+		commandExecutor := executor
+		if options.executors != nil {
+			commandExecutor = options.executors[i]
+		}
+		shell := options.shell
+		if options.shells != nil {
+			shell = options.shells[i]
+		}
+		commandCtx := ctx
+		if options.timeouts != nil && options.timeouts[i] > 0 {
+			var cancel context.CancelFunc
+			commandCtx, cancel = context.WithTimeout(ctx, options.timeouts[i])
+			defer cancel()
+		}
+		running.Add(1)
+		if options.metrics != nil {
+			options.metrics.running.Add(1)
+		}
+		result := commandExecutor.Run(commandCtx, shell, command)
+		running.Add(-1)
+		if options.metrics != nil {
+			options.metrics.running.Add(-1)
+		}
+		release()
+		if options.trimOutput {
+			result.Stdout = strings.TrimRight(result.Stdout, "\n")
+			result.Stderr = strings.TrimRight(result.Stderr, "\n")
+		}
+		if options.success != nil {
+			succeed := options.success.check(result)
+			if succeed != result.Succeed {
+				traceLog("command success overridden by criteria: %v, succeed=%v, exit_code=%v", command, succeed, result.ExitCode)
+			}
+			result.Succeed = succeed
+		}
+		if options.maxOutputLines > 0 {
+			result.Stdout = lastLines(result.Stdout, options.maxOutputLines)
+			result.Stderr = lastLines(result.Stderr, options.maxOutputLines)
+		}
+		var resultValue any
+		var resultValueError string
+		if options.captureNumber {
+			var err error
+			if resultValue, err = parseNumber(result.Stdout); err != nil {
+				warnLog("failed to capture number: rowid=%v, err=%v", pks[i], err)
+				resultValueError = err.Error()
+			}
+		}
+		var captured map[string]any
+		if options.captureJson {
+			var err error
+			if captured, err = parseCapturedJson(result.Stdout); err != nil {
+				warnLog("failed to capture json: rowid=%v, err=%v", pks[i], err)
+			} else if options.captureJsonGrow && !options.noUpdate {
+				keys := make([]string, 0, len(captured))
+				for key := range captured {
+					keys = append(keys, key)
+				}
+				slices.Sort(keys)
+				added, err := db.AddColumns(keys)
+				if len(added) > 0 {
+					infoLog("added columns for captured json: %v", added)
+				}
+				if err != nil {
+					warnLog("failed to add columns for captured json: rowid=%v, err=%v", pks[i], err)
+				}
+			}
+		}
+		rowPks := []any{pks[i]}
+		if options.batches != nil {
+			rowPks = options.batches[i]
+		}
+		// updated is amount of rows which results were recorded, locked is amount of rows skipped as locked
+		// and unrecorded are rows which results weren't recorded due to the state db error
+		updated, locked := len(rowPks), 0
+		var unrecorded []any
+		var err error
+		for j, pk := range rowPks {
+			if options.noUpdate {
+				break
+			}
+			err = retry(ctx, updateAttempts, updateBackoff, func() error {
+				return db.Update(pk, LiteArgsDbUpdate{
+					Succeed:          result.Succeed,
+					ExitCode:         result.ExitCode,
+					Stdout:           result.Stdout,
+					Stderr:           result.Stderr,
+					Time:             time.Now(),
+					Duration:         result.Duration,
+					State:            result.state(),
+					KeepHistory:      options.keepHistory,
+					CaptureNumber:    options.captureNumber,
+					ResultValue:      resultValue,
+					ResultValueError: resultValueError,
+					Env:              options.recordedEnv,
+					Command:          command,
+					Lock:             options.lockOnSuccess,
+					RunId:            options.runId,
+					Captured:         captured,
+					Force:            options.force,
+				})
+			})
+			if errors.Is(err, ErrRowLocked) {
+				warnLog("result isn't recorded for the locked row: rowid=%v", pk)
+				updated, locked, err = updated-1, locked+1, nil
+				continue
+			}
+			if err != nil {
+				updated, unrecorded = updated-len(rowPks[j:]), rowPks[j:]
+				break
+			}
+		}
+		atomic.AddInt32(&summary.skipped, int32(locked))
+		if err != nil {
+			errorLog("failed to record command result: %v, err=%v", command, err)
+			for _, pk := range unrecorded {
+				if stateErr := db.SetState(pk, dbErrorState); stateErr != nil {
+					traceLog("failed to record db error state: rowid=%v, err=%v", pk, stateErr)
+				}
+			}
+			atomic.AddInt32(&summary.dbErrors, int32(len(unrecorded)))
+			if !options.keepGoing && !aborted.Swap(true) {
+				errorLog("execution stopped due to state db error")
+			}
+		}
+		// batched and deduplicated commands emit the same result for every row they cover
+		for _, pk := range rowPks {
+			emitted := emittedResult{
+				RowId:      pk,
+				Succeed:    result.Succeed,
+				ExitCode:   result.ExitCode,
+				DurationMs: result.Duration.Milliseconds(),
+				Stdout:     result.Stdout,
+				Stderr:     result.Stderr,
+			}
+			if resultsEncoder != nil {
+				resultsLock.Lock()
+				emitErr := resultsEncoder.Encode(emitted)
+				resultsLock.Unlock()
+				if emitErr != nil {
+					traceLog("failed to emit result: %v", emitErr)
+				}
+			}
+			if options.collectResults {
+				resultsLock.Lock()
+				summary.results = append(summary.results, emitted)
+				resultsLock.Unlock()
+			}
+		}
+		if options.outputTemplate != nil {
+			var row map[string]any
+			if options.rows != nil {
+				row = options.rows[i]
+			}
+			if line, err := outputLine(options.outputTemplate, row, pks[i], result); err != nil {
+				traceLog("%v", err)
+			} else {
+				resultsLock.Lock()
+				_, _ = fmt.Fprintln(options.output, line)
+				resultsLock.Unlock()
+			}
+		}
+		retriable := len(options.retryExitCodes) == 0 || slices.Contains(options.retryExitCodes, result.ExitCode)
+		if !result.Succeed && err == nil && locked == 0 && retriable && attempt < options.maxAttempts && !aborted.Load() && ctx.Err() == nil {
+			backoff := options.retryBackoff * time.Duration(1<<(attempt-1))
+			traceLog("command failed on attempt %v/%v, retrying in %v: %v", attempt, options.maxAttempts, backoff, command)
+			atomic.AddInt32(&summary.retried, 1)
+			inflight.Add(1)
+			time.AfterFunc(backoff, func() { dispatch(i, attempt+1) })
+			return
+		}
+		if result.Succeed {
+			atomic.AddInt32(&summary.succeed, int32(updated))
+		} else {
+			atomic.AddInt32(&summary.failed, int32(updated))
+		}
+		if options.metrics != nil {
+			options.metrics.observe(result.Succeed, updated, result.Duration)
+		}
+		if checkpointEvery > 0 && completed.Add(1)%int32(checkpointEvery) == 0 {
+			if err := db.Checkpoint(); err != nil {
+				traceLog("%v", err)
+			} else {
+				atomic.AddInt32(&summary.checkpoints, 1)
+			}
+		}
+	}
+	inflight.Add(len(commands))
+	if len(semaphores) == 0 {
+		for i := range commands {
+			dispatch(i, 1)
+		}
+	} else {
+		// every group is dispatched independently in order to not wait for a busy group before dispatching others
+		var names []string
+		queues := make(map[string][]int)
+		for i := range commands {
+			name := options.groups[i]
+			if _, ok := queues[name]; !ok {
+				names = append(names, name)
+			}
+			queues[name] = append(queues[name], i)
+		}
+		for _, name := range names {
+			go func() {
+				for _, i := range queues[name] {
+					dispatch(i, 1)
+				}
+			}()
+		}
+	}
+	inflight.Wait()
+	_ = group.Wait()
+	return summary
+}
+
+func main() {
+	var (
+		execParallelism     string
+		execTake            int
+		execFilter          string
+		execFilterNot       string
+		execIn              []string
+		execNullValue       string
+		execShowFailing     int
+		execLockOnSuccess   bool
+		execForce           bool
+		execBatchSize       int
+		execRunId           string
+		execOrderSecond     string
+		execTimeout         time.Duration
+		execTimeoutColumn   string
+		execProgressJson    bool
+		execSkipRunning     bool
+		execFilterVars      []string
+		execSql             string
+		execDedup           bool
+		execMetricsAddr     string
+		execRetryFile       string
+		execRetryCommands   bool
+		execRowIdsFile      string
+		execPriorityColumn  string
+		execCaptureJson     bool
+		execCaptureJsonGrow bool
+		execProgressFile    string
+		execProgressEvery   time.Duration
+		execOrder           string
+		execShell           string
+		execShow            bool
+		execClaim           bool
+		execWorker          string
+		execNoUpdate        bool
+		execExplain         bool
+		execPreserveOrder   bool
+		execTemplateFilter  string
+		execEmitResults     bool
+		execKeepGoing       bool
+		execExecutor        string
+		execSSHHost         string
+		execSSHUser         string
+		execSSHKey          string
+		execSSHKnownHosts   string
+		execSSHInsecure     bool
+		execImage           string
+		execEnv             []string
+		execWorkdir         string
+		execCheckpointEvery int
+		execSuccessExit     []int
+		execSuccessRegex    string
+		execTrimOutput      bool
+		execStrict          bool
+		execMaxOutputLines  int
+		execMaxOutputBytes  int
+		execPreExec         string
+		execPostExec        string
+		execKeepHistory     bool
+		execLimit           int
+		execKillSignal      string
+		execKillGrace       time.Duration
+		execLeftDelim       string
+		execRightDelim      string
+		execTag             string
+		execRowIds          []int64
+		execMergeStderr     bool
+		execWhereRaw        string
+		execOrderAttempts   bool
+		execOrderDuration   bool
+		execConfirmLarge    int
+		execYes             bool
+		execFailOnStderr    bool
+		execSince           string
+		execOutputTemplate  string
+		execMaxAttempts     int
+		execRetryBackoff    time.Duration
+		execBackup          bool
+		execIncludeDisabled bool
+		execRetryExitCodes  []int
+		execSummaryFile     string
+		execSummaryResults  bool
+		execGroupBy         string
+		execGroupParallel   int
+		execCaptureNumber   bool
+		execRecordEnv       []string
+		execSkipTmplErrors  bool
+		execCommandColumn   string
+		execLowMemory       bool
+		execOnlyNew         bool
+	)
+	var execCmd = &cobra.Command{
+		Use:   "exec [state.db] [command...]",
+		Short: "Execute a command with the state database",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			parallelism, err := parseParallelism(execParallelism)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if execOrderAttempts && execOrderDuration {
+				fatalLog("--order-by-attempts can't be used together with --order-by-duration")
+			}
+			if (execOrderAttempts || execOrderDuration) && (execOrder != "" || execPreserveOrder) {
+				fatalLog("--order-by-attempts and --order-by-duration can't be used together with --order or --preserve-order")
+			}
+			if execOrderAttempts {
+				execOrder = orderByAttempts
+			} else if execOrderDuration {
+				execOrder = orderByDuration
+			}
+			if execPreserveOrder && execOrder != "" {
+				fatalLog("--preserve-order can't be used together with --order")
+			}
+			for _, expression := range []*string{&execFilter, &execFilterNot, &execWhereRaw} {
+				if *expression, err = renderFilter(*expression, execFilterVars, os.Environ()); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			filter := LiteArgsDbFilter{
+				Take:            execTake,
+				Filter:          execFilter,
+				FilterNot:       execFilterNot,
+				Order:           execOrder,
+				OrderSecondary:  execOrderSecond,
+				PreserveOrder:   execPreserveOrder,
+				PriorityColumn:  execPriorityColumn,
+				Tag:             execTag,
+				RowIds:          execRowIds,
+				Query:           execSql,
+				WhereRaw:        execWhereRaw,
+				IncludeDisabled: execIncludeDisabled,
+				IncludeLocked:   execForce,
+				SkipRunning:     execSkipRunning,
+				RetryExitCodes:  execRetryExitCodes,
+			}
+			if execPriorityColumn != "" && !slices.Contains(db.Columns(), execPriorityColumn) {
+				fatalLog("--priority-column '%v' not found in columns: %v", execPriorityColumn, db.Columns())
+			}
+			if execRowIdsFile != "" {
+				rowIdsFile, err := os.Open(execRowIdsFile)
+				if err != nil {
+					fatalLog("failed to open rowids file: %v", err)
+				}
+				rowIds, err := readRowIds(rowIdsFile)
+				_ = rowIdsFile.Close()
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				if len(rowIds) == 0 {
+					infoLog("rowids file %v is empty: nothing to execute", execRowIdsFile)
+					return
+				}
+				execRowIds = append(execRowIds, rowIds...)
+				filter.RowIds = execRowIds
+			}
+			if execSql != "" && execLowMemory {
+				fatalLog("--sql can't be used together with --low-memory as chunks are selected in rowid order")
+			}
+			if filter.In, err = parseIn(execIn, db.Columns()); err != nil {
+				fatalLog("%v", err)
+			}
+			if execSince != "" {
+				if filter.Since, err = parseTimestamp(execSince); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if len(execRowIds) > 0 {
+				if err = checkRowIds(db, execRowIds, execStrict); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if (execLeftDelim == "") != (execRightDelim == "") {
+				fatalLog("--left-delim and --right-delim must be set together")
+			}
+			leftDelim, rightDelim = execLeftDelim, execRightDelim
+			nullValue = execNullValue
+			command := commandTemplate(args[1:])
+			if execCommandColumn != "" && len(args) > 1 {
+				fatalLog("--command-column can't be used together with the command template")
+			} else if execCommandColumn != "" {
+				if !slices.Contains(db.Columns(), execCommandColumn) {
+					fatalLog("--command-column '%v' not found in columns: %v", execCommandColumn, db.Columns())
+				}
+				command = columnTemplate(execCommandColumn)
+			} else if len(args) < 2 {
+				fatalLog("command template or --command-column must be provided")
+			}
+			if execOnlyNew {
+				if filter.AfterRowId, err = execWatermark(db); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if execExplain {
+				if err = explain(os.Stderr, db, filter); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if !execShow && !execYes && execConfirmLarge > 0 {
+				count, err := db.Count(filter)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				if !confirm(os.Stdin, os.Stderr, count, execConfirmLarge) {
+					fatalLog("execution aborted")
+				}
+			}
+			if execRetryFile != "" && execNoUpdate {
+				fatalLog("--retry-file can't be used together with --no-update as failures are taken from the state db")
+			}
+			if execClaim && execNoUpdate {
+				fatalLog("--claim can't be used together with --no-update as claimed rows will never be released")
+			}
+			if execLowMemory && (execShow || execOrder != "" || execPriorityColumn != "" || execSummaryResults || execPreExec != "" || execPostExec != "") {
+				fatalLog("--low-memory can't be used together with --show, --order, --priority-column, --summary-results, --pre-exec or --post-exec")
+			}
+			if execBatchSize > 1 && (execSkipTmplErrors || execCommandColumn != "" || execDedup) {
+				fatalLog("--batch-size can't be used together with --skip-template-errors, --command-column or --dedup-commands")
+			}
+			if execShow {
+				rows, pks, err := db.Filter(filter)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				if execTemplateFilter != "" {
+					if rows, pks, err = templateFilter(execTemplateFilter, rows); err != nil {
+						fatalLog("%v", err)
+					}
+				}
+				if execBatchSize > 1 || execDedup {
+					var commands []string
+					if execBatchSize > 1 {
+						commands, _, _, err = renderBatches(command, rows, pks, execBatchSize)
+					} else if commands, err = render(command, rows); err == nil {
+						commands, _, _ = dedupCommands(commands, rows, pks)
+					}
+					if err != nil {
+						fatalLog("%v", err)
+					}
+					err = previewCommands(os.Stdout, commands, execLimit)
+				} else {
+					err = preview(os.Stdout, command, rows, execLimit)
+				}
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				return
+			}
+			if execTimeoutColumn != "" && !slices.Contains(db.Columns(), execTimeoutColumn) {
+				fatalLog("--timeout-column '%v' not found in columns: %v", execTimeoutColumn, db.Columns())
+			}
+			if execGroupBy != "" && !slices.Contains(db.Columns(), execGroupBy) {
+				fatalLog("--group-by column '%v' not found in columns: %v", execGroupBy, db.Columns())
+			}
+			killSig, err := killSignal(execKillSignal)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			kill := KillPolicy{Signal: killSig, Grace: execKillGrace}
+			var executor Executor
+			var config *ssh.ClientConfig
+			switch execExecutor {
+			case "local":
+				executor = LocalExecutor{Env: execEnv, Dir: execWorkdir, Kill: kill, MergeStderr: execMergeStderr, MaxOutputBytes: execMaxOutputBytes}
+			case "docker":
+				if execImage == "" {
+					fatalLog("--image must be set for docker executor")
+				}
+				executor = DockerExecutor{Image: execImage, Env: execEnv, Dir: execWorkdir, Kill: kill, MergeStderr: execMergeStderr, MaxOutputBytes: execMaxOutputBytes}
+			case "ssh":
+				if execMergeStderr {
+					fatalLog("--merge-stderr isn't supported for ssh executor")
+				}
+				if config, err = sshConfig(execSSHUser, execSSHKey, execSSHKnownHosts, execSSHInsecure); err != nil {
+					fatalLog("%v", err)
+				}
+			default:
+				fatalLog("unsupported executor: '%v'", execExecutor)
+			}
+			var success *successCriteria
+			if execFailOnStderr && execMergeStderr {
+				fatalLog("--fail-on-stderr can't be used together with --merge-stderr as stderr isn't captured separately")
+			}
+			if len(execSuccessExit) > 0 || execSuccessRegex != "" || execFailOnStderr {
+				success = &successCriteria{exitCodes: execSuccessExit, failOnStderr: execFailOnStderr}
+				if execSuccessRegex != "" {
+					success.stdoutRegex, err = regexp.Compile(execSuccessRegex)
+					if err != nil {
+						fatalLog("failed to compile success regex: %v", err)
+					}
+				}
+			}
+			var execResults io.Writer
+			if execEmitResults {
+				execResults = os.Stdout
+			}
+			var outputTemplate *template.Template
+			if execOutputTemplate != "" {
+				if outputTemplate, err = parseTemplate(execOutputTemplate); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			var recordedEnv string
+			if len(execRecordEnv) > 0 {
+				environ := execEnv
+				if execExecutor == "local" {
+					environ = append(os.Environ(), execEnv...)
+				}
+				if recordedEnv, err = recordEnv(execRecordEnv, environ); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if execBackup && !execNoUpdate {
+				path, err := backup(db, args[0], time.Now())
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				if path != "" {
+					infoLog("state db backed up to %v", path)
+				}
+			}
+			var progress io.Writer
+			if execProgressJson {
+				progress = os.Stderr
+			}
+			if execProgressFile != "" {
+				progressFile, err := os.Create(execProgressFile)
+				if err != nil {
+					fatalLog("failed to create progress file: %v", err)
+				}
+				defer progressFile.Close()
+				progress = progressFile
+			}
+			var metrics *execMetrics
+			if execMetricsAddr != "" {
+				metrics = newExecMetrics()
+				addr, stop, err := serveMetrics(execMetricsAddr, metrics)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				defer stop()
+				infoLog("serving metrics on http://%v/metrics", addr)
+			}
+			// executedRowId is the greatest rowid handed to the execution, it becomes the watermark for --only-new
+			var executedRowId int64
+			runRows := func(rows []map[string]any, pks []any) (execSummary, error) {
+				var err error
+				var commands []string
+				var batches [][]any
+				if execBatchSize > 1 {
+					commands, batches, rows, err = renderBatches(command, rows, pks, execBatchSize)
+					pks = make([]any, len(rows))
+					for i, row := range rows {
+						pks[i] = row["rowid"]
+					}
+				} else if execSkipTmplErrors {
+					rows, pks, commands, err = renderSkipping(command, rows, pks)
+				} else {
+					commands, err = render(command, rows)
+				}
+				if err != nil {
+					return execSummary{}, err
+				}
+				if execDedup {
+					before := len(commands)
+					commands, batches, rows = dedupCommands(commands, rows, pks)
+					pks = make([]any, len(rows))
+					for i, row := range rows {
+						pks[i] = row["rowid"]
+					}
+					infoLog("deduplicated %v commands into %v distinct ones", before, len(commands))
+				}
+				if empty := emptyCommands(pks, commands); execStrict && len(empty) > 0 {
+					return execSummary{}, fmt.Errorf("template rendered empty commands for rowids: %v", empty)
+				}
+				var groups []string
+				if execGroupBy != "" {
+					groups = make([]string, len(rows))
+					for i, row := range rows {
+						groups[i] = fmt.Sprint(exportValue(row[execGroupBy]))
+					}
+				}
+				shells, err := renderShells(execShell, rows, execExecutor == "local")
+				if err != nil {
+					return execSummary{}, err
+				}
+				var timeouts []time.Duration
+				if execTimeout > 0 || execTimeoutColumn != "" {
+					if timeouts, err = rowTimeouts(rows, execTimeoutColumn, execTimeout); err != nil {
+						return execSummary{}, err
+					}
+				}
+				var executors []Executor
+				if config != nil {
+					hosts, err := render(execSSHHost, rows)
+					if err != nil {
+						return execSummary{}, err
+					}
+					executors = make([]Executor, len(hosts))
+					for i, host := range hosts {
+						executors[i] = SSHExecutor{Addr: sshAddr(host), Config: config, Env: execEnv, Dir: execWorkdir, MaxOutputBytes: execMaxOutputBytes}
+					}
+				}
+				executedRowId = max(executedRowId, maxRowId(pks))
+				for _, batch := range batches {
+					executedRowId = max(executedRowId, maxRowId(batch))
+				}
+				return execute(cmd.Context(), db, pks, commands, execOptions{
+					parallelism:      parallelism,
+					shell:            execShell,
+					shells:           shells,
+					groups:           groups,
+					batches:          batches,
+					timeouts:         timeouts,
+					groupParallelism: execGroupParallel,
+					maxAttempts:      execMaxAttempts,
+					retryBackoff:     execRetryBackoff,
+					retryExitCodes:   execRetryExitCodes,
+					outputTemplate:   outputTemplate,
+					output:           os.Stdout,
+					rows:             rows,
+					noUpdate:         execNoUpdate,
+					results:          execResults,
+					keepGoing:        execKeepGoing,
+					executor:         executor,
+					executors:        executors,
+					checkpointEvery:  execCheckpointEvery,
+					success:          success,
+					trimOutput:       execTrimOutput,
+					maxOutputLines:   execMaxOutputLines,
+					preExec:          execPreExec,
+					postExec:         execPostExec,
+					keepHistory:      execKeepHistory,
+					collectResults:   execSummaryFile != "" && execSummaryResults,
+					captureNumber:    execCaptureNumber,
+					captureJson:      execCaptureJson || execCaptureJsonGrow,
+					captureJsonGrow:  execCaptureJsonGrow,
+					recordedEnv:      recordedEnv,
+					lockOnSuccess:    execLockOnSuccess,
+					force:            execForce,
+					runId:            execRunId,
+					metrics:          metrics,
+					progress:         progress,
+					progressInterval: execProgressEvery,
+				}), nil
+			}
+			run := func(rows []map[string]any, pks []any) execSummary {
+				var err error
+				if execTemplateFilter != "" {
+					if rows, pks, err = templateFilter(execTemplateFilter, rows); err != nil {
+						fatalLog("%v", err)
+					}
+				}
+				var summary execSummary
+				if execClaim {
+					summary, err = executeClaimed(db, execWorker, rows, pks, runRows)
+				} else {
+					summary, err = runRows(rows, pks)
+				}
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				return summary
+			}
+			if execRunId == "" {
+				execRunId = newUUID()
+			}
+			infoLog("run id: %v", execRunId)
+			startTime := time.Now()
+			var summary execSummary
+			if execLowMemory {
+				summary, err = executeChunks(cmd.Context(), db, filter, max(lowMemoryChunkSize, parallelism), run)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+			} else {
+				rows, pks, err := db.Filter(filter)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				summary = run(rows, pks)
+			}
+			infoLog("succeed: %v, failed: %v, skipped: %v, retried: %v, db errors: %v, elapsed=%v", summary.succeed, summary.failed, summary.skipped, summary.retried, summary.dbErrors, time.Since(startTime))
+			if !execNoUpdate && summary.dbErrors == 0 && cmd.Context().Err() == nil {
+				if err = advanceExecWatermark(db, executedRowId); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if execRetryFile != "" {
+				failed, err := writeRetryFile(execRetryFile, db, execRunId, execRetryCommands)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				infoLog("%v failed rowids written to %v", failed, execRetryFile)
+			}
+			if execShowFailing > 0 && !execNoUpdate {
+				if err = failingCommands(os.Stderr, db, execRunId, execShowFailing); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if execSummaryFile != "" {
+				if err = writeSummary(execSummaryFile, summary, time.Since(startTime)); err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if summary.dbErrors > 0 || summary.hookErrors > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	execCmd.Flags().StringVarP(&execParallelism, "parallelism", "p", "1", "maximum execution parallelism: number, auto (amount of CPUs) or multiple of CPUs like 2x")
+	execCmd.Flags().BoolVar(&execOnlyNew, "only-new", false, "select only rows inserted after the last row executed by the previous exec runs")
+	execCmd.Flags().BoolVar(&execLowMemory, "low-memory", false, "select, render and execute rows in bounded chunks instead of loading all of them at once")
+	execCmd.Flags().BoolVar(&execProgressJson, "progress-json", false, "periodically write progress as JSON lines with completed, total, running, succeeded, failed and elapsed_ms fields to stderr (totals are per chunk in --low-memory mode)")
+	execCmd.Flags().StringVar(&execProgressFile, "progress-file", "", "write JSON progress lines to the file instead of stderr")
+	execCmd.Flags().DurationVar(&execProgressEvery, "progress-interval", time.Second, "interval between JSON progress lines")
+	execCmd.Flags().DurationVar(&execTimeout, "timeout", 0, "maximum execution time of every command (no limit by default)")
+	execCmd.Flags().StringVar(&execTimeoutColumn, "timeout-column", "", "column with timeout of the row command (Go duration or number of seconds); --timeout is used when it is empty")
+	execCmd.Flags().StringVar(&execOrderSecond, "order-secondary", "rowid ASC", "SQL order appended to the primary order to resolve ties deterministically")
+	execCmd.Flags().StringVar(&execRunId, "run-id", "", "identifier of the run stored in the last_run_id column of every attempted row (random UUID by default)")
+	execCmd.Flags().IntVar(&execBatchSize, "batch-size", 1, "render single command for every batch of N rows available as .rows in the template (e.g. {{ range .rows }}{{ .url }} {{ end }})")
+	execCmd.Flags().BoolVar(&execLockOnSuccess, "lock-on-success", false, "lock succeed rows, so they are never executed or reset again without --force")
+	execCmd.Flags().BoolVar(&execCaptureJson, "capture-json", false, "parse stdout as JSON object and store its fields into the same-named columns")
+	execCmd.Flags().BoolVar(&execCaptureJsonGrow, "capture-json-grow", false, "same as --capture-json but also add columns for the fields missing from the table")
+	execCmd.Flags().StringVar(&execPriorityColumn, "priority-column", "", "run rows with higher value of the column first (empty values count as 0) unless --order is set")
+	execCmd.Flags().StringVar(&execRetryFile, "retry-file", "", "write rowids of rows failed within the run to the file, one per line (can be used later with --rowids-file)")
+	execCmd.Flags().BoolVar(&execRetryCommands, "retry-file-commands", false, "precede every rowid in the --retry-file with its rendered command as # comment")
+	execCmd.Flags().StringVar(&execRowIdsFile, "rowids-file", "", "execute command only for rows with rowids listed one per line in the file (# comments are ignored)")
+	execCmd.Flags().StringVar(&execMetricsAddr, "metrics-addr", "", "serve Prometheus metrics of the run on /metrics of the address (e.g. :9090) until the execution completes")
+	execCmd.Flags().BoolVar(&execDedup, "dedup-commands", false, "execute every distinct rendered command once and record its result for all rows which rendered it")
+	execCmd.Flags().StringVar(&execSql, "sql", "", "custom SELECT query (e.g. with joins) which rows are narrowed down by other selection flags; its result must include rowid and columns used by the command template")
+	execCmd.Flags().StringArrayVar(&execFilterVars, "filter-var", nil, "KEY=VALUE available as {{ .KEY }} in --filter, --filter-not and --where-raw templates together with environment as {{ .env.NAME }} (can be repeated)")
+	execCmd.Flags().BoolVar(&execSkipRunning, "skip-running", false, "skip rows marked as running by another liteargs process (lighter alternative to --claim)")
+	execCmd.Flags().BoolVar(&execForce, "force", false, "select also rows locked with --lock-on-success (e.g. together with --where-raw)")
+	execCmd.Flags().IntVar(&execShowFailing, "show-failing-commands", 0, "print at most N commands which failed during the run after its completion")
+	execCmd.Flags().StringVar(&execNullValue, "null-value", "", "value rendered in templates instead of NULL column values")
+	execCmd.Flags().StringVar(&execCommandColumn, "command-column", "", "execute literal commands stored in the column instead of rendering the command template")
+	execCmd.Flags().BoolVar(&execSkipTmplErrors, "skip-template-errors", false, "skip rows which failed to render the command template instead of aborting the whole run")
+	execCmd.Flags().StringSliceVar(&execRecordEnv, "record-env", nil, "comma separated environment keys which effective values are stored as JSON in the last_env column")
+	execCmd.Flags().BoolVar(&execCaptureNumber, "capture-number", false, "store stdout parsed as a number in the result_value column; parsing error is stored in the result_value_error column")
+	execCmd.Flags().StringVar(&execGroupBy, "group-by", "", "column which groups rows for --group-parallelism limit")
+	execCmd.Flags().IntVar(&execGroupParallel, "group-parallelism", 1, "maximum execution parallelism within every group of rows with the same --group-by column value")
+	execCmd.Flags().StringVar(&execSummaryFile, "summary-file", "", "write execution summary as JSON to the file")
+	execCmd.Flags().BoolVar(&execSummaryResults, "summary-results", false, "include results of all completed commands in the --summary-file")
+	execCmd.Flags().IntVarP(&execTake, "take", "t", 0, "execute command only for first element; -1 removes any limits")
+	execCmd.Flags().StringVar(&execFilter, "filter", "", "arbitrary SQL filter")
+	execCmd.Flags().StringArrayVar(&execIn, "in", nil, "select only rows where the column has one of the comma separated values: column=value1,value2 (can be repeated)")
+	execCmd.Flags().StringVar(&execFilterNot, "filter-not", "", "arbitrary SQL filter excluding matching rows (combined with --filter)")
+	execCmd.Flags().BoolVar(&execOrderAttempts, "order-by-attempts", false, "execute rows with most attempts first, same as --order '"+orderByAttempts+"'")
+	execCmd.Flags().BoolVar(&execOrderDuration, "order-by-duration", false, "execute rows with the slowest last attempt first, same as --order '"+orderByDuration+"'")
+	execCmd.Flags().StringVar(&execWhereRaw, "where-raw", "", "arbitrary SQL condition replacing the whole WHERE clause including the succeed = 0 constraint (already succeed rows can be executed again)")
+	execCmd.Flags().StringVar(&execOrder, "order", "", "arbitrary SQL filter")
+	execCmd.Flags().StringVar(&execTemplateFilter, "template-filter", "", "boolean template predicate evaluated for every selected row, e.g. '{{ hasSuffix .url \".com\" }}'")
+	execCmd.Flags().BoolVar(&execPreserveOrder, "preserve-order", false, "select rows in the insertion order")
+	execCmd.Flags().StringVar(&execShell, "shell", "sh", "shell for commands execution; can be a template rendered for every row")
+	execCmd.Flags().StringVar(&execExecutor, "executor", "local", "command executor: local, docker or ssh")
+	execCmd.Flags().StringVar(&execImage, "image", "", "docker image for docker executor")
+	execCmd.Flags().StringArrayVar(&execEnv, "env", nil, "KEY=VALUE environment variable for commands (set on the remote host for ssh executor)")
+	execCmd.Flags().StringVar(&execWorkdir, "workdir", "", "working directory for commands (on the remote host for ssh executor)")
+	execCmd.Flags().BoolVar(&execMergeStderr, "merge-stderr", false, "capture stderr together with stdout: merged output is matched by --success-regex and stored in last_stdout while last_stderr stays empty")
+	execCmd.Flags().StringVar(&execKillSignal, "kill-signal", "SIGINT", "signal sent to running commands on interruption: SIGINT, SIGTERM or SIGKILL")
+	execCmd.Flags().DurationVar(&execKillGrace, "kill-grace", 0, "time given to interrupted commands to exit before SIGKILL; 0 kills immediately")
+	execCmd.Flags().StringVar(&execSSHHost, "ssh-host", "{{ .host }}", "template of the ssh host for every row")
+	execCmd.Flags().StringVar(&execSSHUser, "ssh-user", os.Getenv("USER"), "ssh user")
+	execCmd.Flags().StringVar(&execSSHKey, "ssh-key", filepath.Join(homeDir(), ".ssh", "id_rsa"), "ssh private key file")
+	execCmd.Flags().StringVar(&execSSHKnownHosts, "ssh-known-hosts", filepath.Join(homeDir(), ".ssh", "known_hosts"), "ssh known hosts file")
+	execCmd.Flags().BoolVar(&execSSHInsecure, "ssh-insecure", false, "skip ssh host key verification")
+	execCmd.Flags().Int64SliceVar(&execRowIds, "rowids", nil, "execute command only for rows with given comma separated rowids")
+	execCmd.Flags().StringVar(&execSince, "since", "", "execute command only for rows loaded after the timestamp (e.g. '2024-08-10 23:12:54')")
+	execCmd.Flags().BoolVar(&execIncludeDisabled, "include-disabled", false, "execute command also for rows disabled with 0 or false value in the enabled column")
+	execCmd.Flags().StringVar(&execTag, "tag", "", "execute command only for rows loaded with the tag")
+	execCmd.Flags().IntVar(&execConfirmLarge, "confirm-large-run", 1000, "ask for confirmation if more commands will be executed; 0 disables confirmation")
+	execCmd.Flags().BoolVarP(&execYes, "yes", "y", false, "don't ask for confirmation of large runs")
+	execCmd.Flags().BoolVar(&execShow, "show", false, "show commands but do not execute them")
+	execCmd.Flags().StringVar(&execLeftDelim, "left-delim", "", "left delimiter of template actions instead of {{")
+	execCmd.Flags().StringVar(&execRightDelim, "right-delim", "", "right delimiter of template actions instead of }}")
+	execCmd.Flags().IntVar(&execLimit, "limit", 0, "show only first commands with --show; 0 shows all commands")
+	execCmd.Flags().BoolVar(&execClaim, "claim", false, "reserve selected rows before execution so concurrent liteargs processes never run the same row")
+	execCmd.Flags().BoolVar(&execNoUpdate, "no-update", false, "execute commands without recording their results in the state db")
+	execCmd.Flags().BoolVar(&execEmitResults, "emit-results", false, "print JSON line with result of every completed command to stdout")
+	execCmd.Flags().StringVar(&execPreExec, "pre-exec", "", "command executed once before the batch")
+	execCmd.Flags().StringVar(&execPostExec, "post-exec", "", "command executed once after the batch, even if it failed")
+	execCmd.Flags().BoolVar(&execStrict, "strict", false, "fail before execution if template renders empty command for any row or requested rowids are invalid")
+	execCmd.Flags().IntVar(&execMaxOutputBytes, "max-output-bytes", 0, "keep only last N bytes of stdout and stderr in memory while the command is running; 0 removes any limits")
+	execCmd.Flags().IntVar(&execMaxOutputLines, "max-output-lines", 0, "record only last N lines of stdout and stderr; 0 removes any limits")
+	execCmd.Flags().BoolVar(&execKeepHistory, "keep-history", false, "append every attempt to the liteargs_attempts table")
+	execCmd.Flags().BoolVar(&execTrimOutput, "trim-output", false, "strip trailing newlines from recorded stdout and stderr")
+	execCmd.Flags().IntSliceVar(&execSuccessExit, "success-exit", nil, "exit codes treated as success instead of zero exit code")
+	execCmd.Flags().StringVar(&execSuccessRegex, "success-regex", "", "regex which stdout of the successful command must match")
+	execCmd.Flags().BoolVar(&execBackup, "backup", false, "snapshot the state db into the timestamped file next to it before execution")
+	execCmd.Flags().IntVar(&execMaxAttempts, "max-attempts", 1, "retry failed commands within the same execution until they are attempted given amount of times")
+	execCmd.Flags().IntSliceVar(&execRetryExitCodes, "retry-exit-codes", nil, "execute only new rows or rows failed with one of the comma separated exit codes and retry only such failures with --max-attempts")
+	execCmd.Flags().DurationVar(&execRetryBackoff, "retry-backoff", time.Second, "delay before the first retry of the failed command; doubled after every attempt")
+	execCmd.Flags().StringVar(&execOutputTemplate, "output-template", "", "template printed to stdout for every completed command; rendered with row columns and succeed, exit_code, duration_ms, stdout and stderr of the result")
+	execCmd.Flags().BoolVar(&execFailOnStderr, "fail-on-stderr", false, "treat command as failed if it writes anything to stderr even with zero exit code")
+	execCmd.Flags().IntVar(&execCheckpointEvery, "checkpoint-every", 0, "checkpoint state db WAL after every N completed commands; 0 disables periodic checkpoints")
+	execCmd.Flags().BoolVar(&execKeepGoing, "keep-going-after-db-error", false, "continue execution when command result can't be recorded in the state db")
+	execCmd.Flags().BoolVar(&execExplain, "explain", false, "print composed SQL query and its plan before execution")
+	execCmd.Flags().StringVar(&execWorker, "worker", defaultWorker(), "worker name recorded for claimed rows")
+
+	var inspectCmd = &cobra.Command{
+		Use:   "shell [state.db]",
+		Short: "Shell into the liteargs state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			emptyWelcome := ""
+			err := shell.RunShell(shell.ShellConfig{
+				DbUri:          args[0],
+				InF:            os.Stdin,
+				OutF:           os.Stdout,
+				ErrF:           os.Stderr,
+				WelcomeMessage: &emptyWelcome,
+			})
+			if err != nil {
+				fatalLog("failed to exit shell: %v", err)
+			}
+		},
+	}
+
+	var (
+		resetTag    string
+		resetWhere  string
+		resetDryRun bool
+		resetForce  bool
+	)
+	var resetCmd = &cobra.Command{
+		Use:   "reset [state.db]",
+		Short: "Reset the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if resetDryRun {
+				count, err := db.Count(resetFilter(resetTag, resetWhere, resetForce))
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				infoLog("%v rows would be reset", count)
+				return
+			}
+			if err = db.Reset(resetTag, resetWhere, resetForce); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	resetCmd.Flags().StringVar(&resetTag, "tag", "", "reset only rows loaded with the tag")
+	resetCmd.Flags().StringVar(&resetWhere, "filter", "", "reset only rows matching the SQL expression over columns of the state db (including succeed ones)")
+	resetCmd.Flags().BoolVar(&resetForce, "force", false, "reset also rows locked with exec --lock-on-success")
+	resetCmd.Flags().BoolVar(&resetDryRun, "dry-run", false, "report amount of rows which would be reset without changing them")
+
+	var (
+		deleteFilter string
+		deleteTag    string
+		deleteYes    bool
+	)
+	var deleteCmd = &cobra.Command{
+		Use:   "delete [state.db]",
+		Short: "Delete rows matching the filter from the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if deleteFilter == "" && deleteTag == "" {
+				fatalLog("--filter or --tag must be set")
+			}
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			filter := LiteArgsDbFilter{WhereRaw: cmp.Or(deleteFilter, "1 = 1"), Tag: deleteTag, IncludeDisabled: true, IncludeLocked: true}
+			count, err := db.Count(filter)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if count == 0 {
+				infoLog("no rows matched the filter")
+				return
+			}
+			if !deleteYes && !ask(os.Stdin, os.Stderr, fmt.Sprintf("going to delete %v rows", count)) {
+				fatalLog("deletion aborted")
+			}
+			deleted, err := db.Delete(filter)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			infoLog("deleted %v rows", deleted)
+		},
+	}
+	deleteCmd.Flags().StringVar(&deleteFilter, "filter", "", "SQL expression selecting rows to delete (both pending and succeed)")
+	deleteCmd.Flags().StringVar(&deleteTag, "tag", "", "delete only rows loaded with the tag")
+	deleteCmd.Flags().BoolVarP(&deleteYes, "yes", "y", false, "skip the confirmation prompt")
+
+	var tailInterval time.Duration
+	var tailCmd = &cobra.Command{
+		Use:   "tail [state.db]",
+		Short: "Follow new failures in the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = tail(cmd.Context(), os.Stdout, db, tailInterval); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	tailCmd.Flags().DurationVar(&tailInterval, "interval", time.Second, "polling interval")
+
+	var (
+		exportTake          int
+		exportFilter        string
+		exportOrder         string
+		exportSep           string
+		exportOutputFormat  string
+		exportCRLF          bool
+		exportAlwaysQuote   bool
+		exportPreserveOrder bool
+		exportPendingOnly   bool
+		exportStateColumns  []string
+	)
+	var exportCmd = &cobra.Command{
+		Use:   "export [state.db]",
+		Short: "Export rows from the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if exportPreserveOrder && exportOrder != "" {
+				fatalLog("--preserve-order can't be used together with --order")
+			}
+			columns, rows, err := exportRows(db, LiteArgsDbFilter{
+				Take:          exportTake,
+				Filter:        exportFilter,
+				Order:         exportOrder,
+				PreserveOrder: exportPreserveOrder,
+				StateColumns:  exportStateColumns,
+			}, exportPendingOnly)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = export(os.Stdout, exportOutputFormat, csvOutput{sep: separator(exportSep), crlf: exportCRLF, alwaysQuote: exportAlwaysQuote}, columns, rows); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	exportCmd.Flags().IntVarP(&exportTake, "take", "t", 0, "export only first elements; -1 removes any limits")
+	exportCmd.Flags().StringVar(&exportFilter, "filter", "", "arbitrary SQL filter")
+	exportCmd.Flags().StringVar(&exportOrder, "order", "", "arbitrary SQL order")
+	exportCmd.Flags().BoolVar(&exportPreserveOrder, "preserve-order", false, "export rows in the insertion order")
+	exportCmd.Flags().BoolVar(&exportPendingOnly, "pending-only", false, "export only rows which didn't succeed yet")
+	exportCmd.Flags().StringSliceVar(&exportStateColumns, "state-columns", nil, "state columns exported next to the user columns (e.g. succeed,exit_code,last_stdout)")
+	exportCmd.Flags().StringVarP(&exportSep, "separator", "s", ",", "CSV separator: single character or one of tab, pipe, semicolon, space")
+	exportCmd.Flags().StringVar(&exportOutputFormat, "output-format", "csv", "output format: csv, json or jsonl")
+	exportCmd.Flags().BoolVar(&exportCRLF, "crlf", false, "use \\r\\n as CSV line ending")
+	exportCmd.Flags().BoolVar(&exportAlwaysQuote, "always-quote", false, "quote every CSV field, not only ones containing separator, quotes or newlines")
+
+	var (
+		loadNoHeader      bool
+		loadSep           string
+		loadDialect       string
+		loadLazyQuotes    bool
+		loadTrimSpace     bool
+		loadMaxFieldSize  int
+		loadInput         string
+		loadUseColumns    []string
+		loadMaxRows       int
+		loadEmptyAsNull   bool
+		loadResume        bool
+		loadNoInit        bool
+		loadFormat        string
+		loadNullDelimited bool
+		loadStrictSchema  bool
+		loadWithUUID      bool
+		loadWithSeq       bool
+		loadTypesPrefix   string
+		loadTag           string
+		loadGzip          bool
+		loadParallelism   int
+	)
+	var loadCmd = &cobra.Command{
+		Use:   "load [state.db]",
+		Short: "Load the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+
+			if loadDialect != "" {
+				preset, err := dialect(loadDialect)
+				if err != nil {
+					fatalLog("%v", err)
+				}
+				if !cmd.Flags().Changed("separator") {
+					loadSep = string(preset.sep)
+				}
+				if !cmd.Flags().Changed("lazy-quotes") {
+					loadLazyQuotes = preset.lazyQuotes
+				}
+				if !cmd.Flags().Changed("trim-leading-space") {
+					loadTrimSpace = preset.trimLeadingSpace
+				}
+			}
+			if loadNullDelimited {
+				loadFormat = "nul"
+			}
+			format, err := inputFormat(loadFormat, loadInput)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			reader, err := input(cmd.Context(), loadInput, loadGzip)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			defer reader.Close()
+
+			recordNumber, err := load(db, reader, loadOptions{
+				noHeader:         loadNoHeader,
+				sep:              separator(loadSep),
+				lazyQuotes:       loadLazyQuotes,
+				maxLineSize:      loadMaxFieldSize,
+				trimLeadingSpace: loadTrimSpace,
+				useColumns:       loadUseColumns,
+				maxRows:          loadMaxRows,
+				emptyNull:        loadEmptyAsNull,
+				resume:           loadResume,
+				noInit:           loadNoInit,
+				strictSchema:     loadStrictSchema,
+				withUUID:         loadWithUUID,
+				withSeq:          loadWithSeq,
+				typesDirective:   loadTypesPrefix,
+				tag:              loadTag,
+				parallelism:      loadParallelism,
+				format:           format,
+			})
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = recordLoadMeta(db, os.Args, loadSep, loadInput, time.Now()); err != nil {
+				fatalLog("%v", err)
+			}
+			infoLog("successfully loaded %v records", recordNumber)
+		},
+	}
+	loadCmd.Flags().StringVarP(&loadInput, "input", "i", "", "input file or HTTP(S) URL with data")
+	loadCmd.Flags().IntVar(&loadParallelism, "load-parallelism", 1, "amount of concurrent insert transactions; load progress isn't recorded for parallel load, so it can't be resumed")
+	loadCmd.Flags().BoolVar(&loadGzip, "gzip", false, "decompress gzip input")
+	loadCmd.Flags().StringVarP(&loadSep, "separator", "s", ",", "CSV separator: single character or one of tab, pipe, semicolon, space")
+	loadCmd.Flags().StringVar(&loadDialect, "dialect", "", "CSV preset of separator, quoting and trimming: excel, unix, pipe or semicolon; explicit flags override the preset")
+	loadCmd.Flags().BoolVar(&loadLazyQuotes, "lazy-quotes", false, "accept quotes inside unquoted fields and non-doubled quotes inside quoted fields")
+	loadCmd.Flags().BoolVar(&loadTrimSpace, "trim-leading-space", false, "ignore leading white space of CSV fields")
+	loadCmd.Flags().IntVar(&loadMaxFieldSize, "max-field-size", 0, "fail on the input line (and so any field) longer than N bytes instead of buffering it in memory")
+	loadCmd.Flags().BoolVar(&loadNoHeader, "no-header", false, "CSV have no header provided")
+	loadCmd.Flags().StringVar(&loadFormat, "format", "auto", "input format: auto, csv, tsv, json, jsonl or nul; auto detects format by the input file extension")
+	loadCmd.Flags().StringVar(&loadTag, "tag", "", "tag stored with every loaded row which can be used to select rows in exec and reset")
+	loadCmd.Flags().BoolVar(&loadWithUUID, "with-uuid", false, "add uuid column with random UUID generated for every loaded row")
+	loadCmd.Flags().StringVar(&loadTypesPrefix, "types-directive", "#types:", "prefix of the optional CSV line before the header declaring column types like '#types: size=INTEGER, ratio=REAL' (empty to disable)")
+	loadCmd.Flags().BoolVar(&loadWithSeq, "with-seq", false, "add seq column with sequence number of every loaded row continuing the greatest existing one")
+	loadCmd.Flags().BoolVar(&loadStrictSchema, "strict-schema", false, "abort if existing table columns differ from the input header in names or order")
+	loadCmd.Flags().BoolVarP(&loadNullDelimited, "null-delimited", "0", false, "input consists of NUL-terminated single-column records without a header, same as --format nul")
+	loadCmd.Flags().BoolVar(&loadNoInit, "no-init", false, "append records to the existing table validating that CSV header matches its columns")
+	loadCmd.Flags().BoolVar(&loadResume, "resume", false, "continue interrupted load of the input file from the last recorded offset")
+	loadCmd.Flags().BoolVar(&loadEmptyAsNull, "empty-as-null", false, "load empty CSV fields as NULL values")
+	loadCmd.Flags().IntVar(&loadMaxRows, "max-rows", 0, "stop loading after given amount of records; 0 removes any limits")
+	loadCmd.Flags().StringSliceVar(&loadUseColumns, "use-columns", nil, "load only given CSV columns selected by header name")
+
+	var statusCountSuccess bool
+	var statusCmd = &cobra.Command{
+		Use:   "status [state.db]",
+		Short: "Print execution status of the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = status(os.Stdout, db, statusCountSuccess); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	statusCmd.Flags().BoolVar(&statusCountSuccess, "count-success", false, "break down succeed rows by exit code too")
+
+	var distinctCmd = &cobra.Command{
+		Use:   "distinct [state.db] [column]",
+		Short: "Print distinct values of the column with their counts",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = distinct(os.Stdout, db, args[1]); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+
+	var inferSample int
+	var inferDryRun bool
+	var inferTypesCmd = &cobra.Command{
+		Use:   "infer-types [state.db]",
+		Short: "Infer INTEGER/REAL/TEXT types of the user columns from their values and rebuild the table with them",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			types, err := db.InferTypes(inferSample)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			for _, column := range db.Columns()[1:] {
+				fmt.Printf("%v %v\n", column, types[column])
+			}
+			if inferDryRun {
+				return
+			}
+			if err = db.Retype(types); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	inferTypesCmd.Flags().IntVar(&inferSample, "sample", 1000, "amount of rows sampled to infer types (all rows if not positive)")
+	inferTypesCmd.Flags().BoolVar(&inferDryRun, "dry-run", false, "only print inferred types without rebuilding the table")
+
+	var getJson bool
+	var getCmd = &cobra.Command{
+		Use:   "get [state.db] [rowid]",
+		Short: "Print the row from the state database",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			primaryKey, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				fatalLog("rowid must be an integer, got: '%v'", args[1])
+			}
+			columns, row, err := db.Get(primaryKey)
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			if err = printRow(os.Stdout, columns, row, getJson); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	getCmd.Flags().BoolVar(&getJson, "json", false, "print the row as a single JSON object")
+
+	var browseShell string
+	var browseForce bool
+	var browseCmd = &cobra.Command{
+		Use:   "browse [state.db] [command...]",
+		Short: "Interactively browse rows of the state database and retry selected rows with the command",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			b := &browser{db: db, shell: browseShell, force: browseForce}
+			if len(args) > 1 {
+				b.command = commandTemplate(args[1:])
+			}
+			if err = browse(cmd.Context(), os.Stdin, os.Stdout, b); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+	browseCmd.Flags().StringVar(&browseShell, "shell", "sh", "shell for the retried commands")
+	browseCmd.Flags().BoolVar(&browseForce, "force", false, "retry also rows locked with exec --lock-on-success")
+
+	var schemaCmd = &cobra.Command{
+		Use:   "schema [state.db]",
+		Short: "Print schema of the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			schema, err := db.Schema()
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			for _, statement := range schema.Statements {
+				fmt.Printf("%v;\n", statement)
+			}
+			fmt.Printf("user columns: %v\n", strings.Join(schema.UserColumns, ", "))
+			fmt.Printf("bookkeeping columns: %v\n", strings.Join(schema.StateColumns, ", "))
+		},
+	}
+
+	var metaCmd = &cobra.Command{
+		Use:   "meta [state.db]",
+		Short: "Print metadata of the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := NewLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			meta, err := db.Meta()
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			for _, entry := range meta {
+				fmt.Printf("%v: %v\n", entry.Key, entry.Value)
+			}
+		},
+	}
+
+	var versionCmd = &cobra.Command{
+		Use:   "version [state.db]",
+		Short: "Print version information and optional state database stats",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var db *LiteArgsDb
+			if len(args) == 1 {
+				var err error
+				db, err = NewLiteArgsDb(args[0])
+				if err != nil {
+					fatalLog("%v", err)
+				}
+			}
+			if err := version(os.Stdout, db); err != nil {
+				fatalLog("%v", err)
+			}
+		},
+	}
+
+	var pingCmd = &cobra.Command{
+		Use:   "ping [state.db]",
+		Short: "Check connectivity to the state database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := OpenLiteArgsDb(args[0])
+			if err != nil {
+				fatalLog("failed to ping liteargs state db: %v", err)
+			}
+			latency, err := db.Ping(cmd.Context())
+			if err != nil {
+				fatalLog("%v", err)
+			}
+			okLog("state db is reachable: latency=%v", latency)
+		},
+	}
+
+	var rootMasks, rootExtensions []string
+	var rootAuthToken string
+	var rootCmd = &cobra.Command{
+		Use: "liteargs",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logMasks = compileMasks(rootMasks)
+			extensions = rootExtensions
+			authToken = rootAuthToken
+		},
+	}
+	rootCmd.PersistentFlags().StringArrayVar(&rootExtensions, "load-extension", nil, "path of SQLite extension loaded into the state db connections, e.g. to use custom functions in --filter (can be repeated)")
+	rootCmd.PersistentFlags().StringVar(&rootAuthToken, "auth-token", os.Getenv("LITEARGS_AUTH_TOKEN"), "auth token of the remote libsql state db given as libsql://, https:// or wss:// URL (defaults to LITEARGS_AUTH_TOKEN env)")
+	rootCmd.PersistentFlags().StringArrayVar(&rootMasks, "mask", nil, "regex (or literal if it isn't a valid regex) of sensitive values redacted from logs")
+	rootCmd.AddCommand(execCmd, inspectCmd, resetCmd, deleteCmd, loadCmd, tailCmd, exportCmd, versionCmd, metaCmd, getCmd, statusCmd, browseCmd, schemaCmd, pingCmd, distinctCmd, inferTypesCmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandTemplate(t *testing.T) {
+	commands, err := render(commandTemplate([]string{"curl", "--silent", "'{{ .url }}'"}), []map[string]any{
+		{"url": "https://google.com"},
+		{"url": "https://turso.tech"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"curl --silent 'https://google.com'",
+		"curl --silent 'https://turso.tech'",
+	}, commands)
+}
+
+func TestRenderDelims(t *testing.T) {
+	leftDelim, rightDelim = "[[", "]]"
+	t.Cleanup(func() { leftDelim, rightDelim = "", "" })
+	commands, err := render(`echo {a,b}-[[ .name ]] '{{"k": "[[ upper .name ]]"}}'`, []map[string]any{{"name": "x"}})
+	require.Nil(t, err)
+	require.Equal(t, []string{`echo {a,b}-x '{{"k": "X"}}'`}, commands)
+}
+
+func TestColumnTemplate(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"cmd"}, []string{"echo '{{ .cmd }}' $((1 + 2))"}, []string{"exit 3"})
+	require.Nil(t, db.InsertValues([]any{nil}))
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render(columnTemplate("cmd"), rows)
+	require.Nil(t, err)
+	require.Equal(t, []string{"echo '{{ .cmd }}' $((1 + 2))", "exit 3", ""}, commands)
+
+	literals, err := render(columnTemplate("cmd"), []map[string]any{{"cmd": int64(0)}, {"cmd": false}, {"cmd": ""}, {"cmd": 0.0}})
+	require.Nil(t, err)
+	require.Equal(t, []string{"0", "false", "", "0"}, literals)
+
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"})
+	require.Equal(t, execSummary{succeed: 1, failed: 1, skipped: 1}, summary)
+	_, row, err := db.Get(pks[0])
+	require.Nil(t, err)
+	require.Equal(t, "{{ .cmd }} 3\n", row["last_stdout"])
+
+	leftDelim, rightDelim = "[[", "]]"
+	t.Cleanup(func() { leftDelim, rightDelim = "", "" })
+	commands, err = render(columnTemplate("cmd"), rows[1:2])
+	require.Nil(t, err)
+	require.Equal(t, []string{"exit 3"}, commands)
+}
+
+func TestPreviewLimit(t *testing.T) {
+	captureLogs(t)
+	rows := []map[string]any{{"name": "a"}, {"name": "bb"}, {"name": int64(1)}}
+	var output bytes.Buffer
+	require.Nil(t, preview(&output, "echo {{ .name }} {{ len .name }}", rows, 2))
+	require.Equal(t, "echo a 1\necho bb 2\n", output.String())
+
+	output.Reset()
+	require.NotNil(t, preview(&output, "echo {{ .name }} {{ len .name }}", rows, 0))
+}
+
+func TestPreviewBatches(t *testing.T) {
+	captureLogs(t)
+	rows := []map[string]any{{"rowid": int64(1), "name": "a"}, {"rowid": int64(2), "name": "b"}, {"rowid": int64(3), "name": "c"}}
+	commands, _, _, err := renderBatches("echo{{ range .rows }} {{ .name }}{{ end }}", rows, []any{int64(1), int64(2), int64(3)}, 2)
+	require.Nil(t, err)
+	var output bytes.Buffer
+	require.Nil(t, previewCommands(&output, commands, 0))
+	require.Equal(t, "echo a b\necho c\n", output.String())
+
+	output.Reset()
+	require.Nil(t, previewCommands(&output, commands, 1))
+	require.Equal(t, "echo a b\n", output.String())
+}
+
+func TestPreviewDedup(t *testing.T) {
+	captureLogs(t)
+	rows := []map[string]any{{"rowid": int64(1), "name": "a"}, {"rowid": int64(2), "name": "b"}, {"rowid": int64(3), "name": "a"}}
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	commands, _, _ = dedupCommands(commands, rows, []any{int64(1), int64(2), int64(3)})
+	var output bytes.Buffer
+	require.Nil(t, previewCommands(&output, commands, 0))
+	require.Equal(t, "echo a\necho b\n", output.String())
+}
+
+// testDb creates state db in the temporary directory with the table initialized by the header if it is not nil
+func testDb(t *testing.T, header []string, records ...[]string) *LiteArgsDb {
+	db, err := NewLiteArgsDb(filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	if header == nil {
+		return db
+	}
+	require.Nil(t, db.Init(header))
+	for _, record := range records {
+		require.Nil(t, db.Insert(record))
+	}
+	return db
+}
+
+func TestExplain(t *testing.T) {
+	db := testDb(t, []string{"name", "url"}, []string{"n-1", "https://google.com"})
+	var buffer bytes.Buffer
+	require.Nil(t, explain(&buffer, db, LiteArgsDbFilter{Take: 10, Filter: "url LIKE '%.com'", Order: "name DESC"}))
+	output := buffer.String()
+	require.Contains(t, output, "SELECT rowid, name, url FROM liteargs")
+	require.Contains(t, output, "WHERE (url LIKE '%.com') AND succeed = 0")
+	require.Contains(t, output, "ORDER BY name DESC")
+	require.Contains(t, output, "LIMIT 10")
+	require.Contains(t, output, "SCAN liteargs")
+}
+
+type lockedBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+func TestTail(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"})
+	ctx, cancel := context.WithCancel(context.Background())
+	var output lockedBuffer
+	done := make(chan error)
+	go func() { done <- tail(ctx, &output, db, 10*time.Millisecond) }()
+
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Stderr: "first failure", Time: time.Now()}))
+	require.Nil(t, db.Update(int64(2), LiteArgsDbUpdate{Succeed: true, Stdout: "ok", Time: time.Now()}))
+	require.Eventually(t, func() bool { return strings.Contains(output.String(), "first failure") }, time.Second, 10*time.Millisecond)
+
+	require.Nil(t, db.Update(int64(3), LiteArgsDbUpdate{Stderr: "second failure", Time: time.Now()}))
+	require.Eventually(t, func() bool { return strings.Contains(output.String(), "second failure") }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.Nil(t, <-done)
+	require.Equal(t, 1, strings.Count(output.String(), "first failure"))
+	require.NotContains(t, output.String(), "rowid=2")
+}
+
+func TestExport(t *testing.T) {
+	db := testDb(t, []string{"name", "url"}, []string{"n-1", "https://google.com"}, []string{"n,2", "https://turso.tech"})
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "csv", csvOutput{sep: ','}, db.Columns(), rows))
+		require.Equal(t, "rowid,name,url\n1,n-1,https://google.com\n2,\"n,2\",https://turso.tech\n", buffer.String())
+	}
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "csv", csvOutput{sep: '\t'}, db.Columns(), rows))
+		require.Equal(t, "rowid\tname\turl\n1\tn-1\thttps://google.com\n2\tn,2\thttps://turso.tech\n", buffer.String())
+	}
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "json", csvOutput{sep: ','}, db.Columns(), rows))
+		var objects []map[string]any
+		require.Nil(t, json.Unmarshal(buffer.Bytes(), &objects))
+		require.Equal(t, []map[string]any{
+			{"rowid": float64(1), "name": "n-1", "url": "https://google.com"},
+			{"rowid": float64(2), "name": "n,2", "url": "https://turso.tech"},
+		}, objects)
+	}
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "jsonl", csvOutput{sep: ','}, db.Columns(), rows))
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		require.Len(t, lines, 2)
+		var object map[string]any
+		require.Nil(t, json.Unmarshal([]byte(lines[1]), &object))
+		require.Equal(t, map[string]any{"rowid": float64(2), "name": "n,2", "url": "https://turso.tech"}, object)
+	}
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "csv", csvOutput{sep: ',', crlf: true}, db.Columns(), rows))
+		require.Equal(t, "rowid,name,url\r\n1,n-1,https://google.com\r\n2,\"n,2\",https://turso.tech\r\n", buffer.String())
+	}
+	{
+		var buffer bytes.Buffer
+		quoted := []map[string]any{{"rowid": int64(3), "name": "say \"hi\"", "url": nil}}
+		require.Nil(t, export(&buffer, "csv", csvOutput{sep: ';', alwaysQuote: true}, db.Columns(), quoted))
+		require.Equal(t, "\"rowid\";\"name\";\"url\"\n\"3\";\"say \"\"hi\"\"\";\"\"\n", buffer.String())
+	}
+	{
+		var buffer bytes.Buffer
+		require.Nil(t, export(&buffer, "csv", csvOutput{sep: ',', crlf: true, alwaysQuote: true}, db.Columns(), rows[:1]))
+		require.Equal(t, "\"rowid\",\"name\",\"url\"\r\n\"1\",\"n-1\",\"https://google.com\"\r\n", buffer.String())
+	}
+	require.NotNil(t, export(io.Discard, "xml", csvOutput{sep: ','}, db.Columns(), rows))
+
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, ExitCode: 0, Stdout: "ok", Lock: true}))
+	columns, rows, err := exportRows(db, LiteArgsDbFilter{PreserveOrder: true, StateColumns: []string{"succeed", "last_stdout"}}, false)
+	require.Nil(t, err)
+	var buffer bytes.Buffer
+	require.Nil(t, export(&buffer, "csv", csvOutput{sep: ','}, columns, rows))
+	require.Equal(t, "rowid,name,url,succeed,last_stdout\n1,n-1,https://google.com,1,ok\n2,\"n,2\",https://turso.tech,0,\n", buffer.String())
+
+	columns, rows, err = exportRows(db, LiteArgsDbFilter{Filter: "name LIKE 'n%'"}, true)
+	require.Nil(t, err)
+	require.Equal(t, []string{"rowid", "name", "url"}, columns)
+	require.Equal(t, []map[string]any{{"rowid": int64(2), "name": "n,2", "url": "https://turso.tech"}}, rows)
+
+	_, _, err = exportRows(db, LiteArgsDbFilter{StateColumns: []string{"name"}}, false)
+	require.ErrorContains(t, err, "unknown state column: 'name'")
+}
+
+func TestVersion(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, Time: time.Now()}))
+	var buffer bytes.Buffer
+	require.Nil(t, version(&buffer, db))
+	output := buffer.String()
+	require.Regexp(t, `(?m)^liteargs: \S+$`, output)
+	require.Regexp(t, `(?m)^go: go\S+$`, output)
+	require.Regexp(t, `(?m)^sqlite: 3\.\S+$`, output)
+	require.Contains(t, output, "columns: rowid, name\n")
+	require.Contains(t, output, "rows: total=2, succeed=1, failed=0, pending=1\n")
+}
+
+func TestTemplateFilter(t *testing.T) {
+	rows := []map[string]any{
+		{"rowid": int64(1), "url": "https://google.com"},
+		{"rowid": int64(2), "url": "https://turso.tech"},
+		{"rowid": int64(3), "url": "https://example.com"},
+	}
+	filtered, pks, err := templateFilter(`{{ hasSuffix .url ".com" }}`, rows)
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{rows[0], rows[2]}, filtered)
+	require.Equal(t, []any{int64(1), int64(3)}, pks)
+
+	_, _, err = templateFilter(`{{ .url }}`, rows)
+	require.NotNil(t, err)
+}
+
+func captureLogs(t *testing.T) *lockedBuffer {
+	var logs lockedBuffer
+	previous := logWriter
+	logWriter = &logs
+	t.Cleanup(func() { logWriter = previous })
+	return &logs
+}
+
+func TestRenderNull(t *testing.T) {
+	rows := []map[string]any{{"rowid": int64(1), "name": "n-1", "region": nil}}
+	commands, err := render("deploy {{ .name }} {{ .region }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, []string{"deploy n-1 "}, commands)
+	commands, err = render(`deploy {{ if .region }}--region {{ .region }}{{ end }}`, rows)
+	require.Nil(t, err)
+	require.Equal(t, []string{"deploy "}, commands)
+	require.Nil(t, rows[0]["region"])
+
+	nullValue = "NULL"
+	t.Cleanup(func() { nullValue = "" })
+	commands, err = render("deploy {{ .name }} {{ .region }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, []string{"deploy n-1 NULL"}, commands)
+	var buffer bytes.Buffer
+	require.Nil(t, preview(&buffer, "deploy {{ .region }}", rows, 0))
+	require.Equal(t, "deploy NULL\n", buffer.String())
+}
+
+func TestRenderFilter(t *testing.T) {
+	db := testDb(t, []string{"name", "created"}, []string{"n-1", "2023-12-31"}, []string{"n-2", "2024-01-01"}, []string{"n-3", "2024-01-02"})
+	filter, err := renderFilter("created < '{{ .today }}' AND name != '{{ .env.SKIPPED }}'", []string{"today=2024-01-02"}, []string{"SKIPPED=n-1", "OTHER=1"})
+	require.Nil(t, err)
+	require.Equal(t, "created < '2024-01-02' AND name != 'n-1'", filter)
+	_, pks, err := db.Filter(LiteArgsDbFilter{Filter: filter})
+	require.Nil(t, err)
+	require.Equal(t, []any{int64(2)}, pks)
+
+	filter, err = renderFilter("name = 'n-1'", []string{"today"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, "name = 'n-1'", filter)
+	_, err = renderFilter("created < '{{ .today }}'", []string{"today"}, nil)
+	require.ErrorContains(t, err, "filter var must be KEY=VALUE pair")
+	_, err = renderFilter("created < '{{ .today }}'", nil, nil)
+	require.ErrorContains(t, err, "failed to render filter template")
+}
+
+func TestParseIn(t *testing.T) {
+	in, err := parseIn([]string{"host=a,b", `name="x,y",'z'`}, []string{"rowid", "host", "name"})
+	require.Nil(t, err)
+	require.Equal(t, []LiteArgsDbIn{{Column: "host", Values: []string{"a", "b"}}, {Column: "name", Values: []string{"x,y", "'z'"}}}, in)
+
+	for _, selection := range []string{"host", "host=", "missing=a", `host="a`} {
+		_, err = parseIn([]string{selection}, []string{"rowid", "host"})
+		require.NotNil(t, err, selection)
+	}
+}
+
+func TestParseParallelism(t *testing.T) {
+	for _, tt := range []struct {
+		value    string
+		expected int
+	}{
+		{value: "1", expected: 1},
+		{value: "16", expected: 16},
+		{value: "auto", expected: runtime.NumCPU()},
+		{value: "1x", expected: runtime.NumCPU()},
+		{value: "2x", expected: 2 * runtime.NumCPU()},
+	} {
+		parallelism, err := parseParallelism(tt.value)
+		require.Nil(t, err)
+		require.Equal(t, tt.expected, parallelism, tt.value)
+	}
+	for _, value := range []string{"", "0", "-1", "0x", "x", "two", "1.5x", "autox"} {
+		_, err := parseParallelism(value)
+		require.NotNil(t, err, value)
+	}
+}
+
+func TestResetDryRun(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	_, err := load(db, strings.NewReader("name\nn-1\nn-2\n"), loadOptions{sep: ',', tag: "batch-1"})
+	require.Nil(t, err)
+	_, err = load(db, strings.NewReader("name\nn-3\n"), loadOptions{sep: ',', tag: "batch-2"})
+	require.Nil(t, err)
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 3}, execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"}))
+
+	count, err := db.Count(resetFilter("batch-1", "", false))
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	count, err = db.Count(resetFilter("", "", false))
+	require.Nil(t, err)
+	require.Equal(t, 3, count)
+	count, err = db.Count(resetFilter("batch-1", "name = 'n-2'", false))
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	stats, err := db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, 3, stats.Succeed)
+
+	require.Nil(t, db.Reset("batch-1", "name = 'n-2'", false))
+	stats, err = db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, 2, stats.Succeed)
+	require.Equal(t, 1, stats.Pending)
+
+	require.Nil(t, db.Reset("batch-1", "", false))
+	stats, err = db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, 1, stats.Succeed)
+	require.Equal(t, 2, stats.Pending)
+
+	require.ErrorContains(t, db.Reset("", "1 = 1; DROP TABLE liteargs", false), "statement separator")
+}
+
+func TestCheckRowIds(t *testing.T) {
+	logs := captureLogs(t)
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"})
+	require.Nil(t, checkRowIds(db, []int64{1, 3}, true))
+	require.Empty(t, logs.String())
+
+	require.Nil(t, checkRowIds(db, []int64{1, 3, 1, 7}, false))
+	require.Contains(t, logs.String(), "duplicate rowids requested: [1]")
+	require.Contains(t, logs.String(), "requested rowids not found: [7]")
+	require.NotNil(t, checkRowIds(db, []int64{2, 7}, true))
+
+	rows, _, err := db.Filter(LiteArgsDbFilter{RowIds: []int64{1, 3, 7}})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n-1"}, {"rowid": int64(3), "name": "n-3"}}, rows)
+}
+
+func TestOrderByAttemptsAndDuration(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"}, []string{"n-4"})
+	for _, update := range []struct {
+		pk       int64
+		duration time.Duration
+	}{
+		{pk: 1, duration: 50 * time.Millisecond}, {pk: 1, duration: 10 * time.Millisecond},
+		{pk: 2, duration: 30 * time.Millisecond}, {pk: 2, duration: 30 * time.Millisecond}, {pk: 2, duration: 20 * time.Millisecond},
+		{pk: 3, duration: time.Second},
+	} {
+		require.Nil(t, db.Update(update.pk, LiteArgsDbUpdate{Time: time.Now(), Duration: update.duration}))
+	}
+	names := func(order string) []any {
+		rows, _, err := db.Filter(LiteArgsDbFilter{Order: order, Take: 3})
+		require.Nil(t, err)
+		result := make([]any, 0, len(rows))
+		for _, row := range rows {
+			result = append(result, row["name"])
+		}
+		return result
+	}
+	require.Equal(t, []any{"n-2", "n-1", "n-3"}, names(orderByAttempts))
+	require.Equal(t, []any{"n-3", "n-2", "n-1"}, names(orderByDuration))
+}
+
+func TestConfirmLargeRun(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"}, []string{"n-3"})
+	count, err := db.Count(LiteArgsDbFilter{Filter: "name <> 'n-2'"})
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	count, err = db.Count(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, 3, count)
+
+	var prompt bytes.Buffer
+	require.True(t, confirm(strings.NewReader(""), &prompt, count, 3))
+	require.True(t, confirm(strings.NewReader(""), &prompt, count, 0))
+	require.Empty(t, prompt.String())
+	require.False(t, confirm(strings.NewReader("n\n"), &prompt, count, 2))
+	require.Contains(t, prompt.String(), "going to execute 3 commands (more than 2), continue? [y/N]")
+	require.False(t, confirm(strings.NewReader(""), &prompt, count, 2))
+	require.True(t, confirm(strings.NewReader("y\n"), &prompt, count, 2))
+}
+
+func TestFilterSince(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"}, []string{"n-2"})
+	_, row, err := db.Get(int64(2))
+	require.Nil(t, err)
+	watermark, err := parseTimestamp(row["loaded_at"].(string))
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = db.InsertBatch([][]any{{"n-3"}, {"n-4"}}, "", nil)
+	require.Nil(t, err)
+
+	rows, _, err := db.Filter(LiteArgsDbFilter{Since: watermark, PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(3), "name": "n-3"}, {"rowid": int64(4), "name": "n-4"}}, rows)
+
+	_, err = parseTimestamp("2024-08-10")
+	require.Nil(t, err)
+	_, err = parseTimestamp("yesterday")
+	require.NotNil(t, err)
+}
+
+func TestLogMasks(t *testing.T) {
+	logs := captureLogs(t)
+	logMasks = compileMasks([]string{`token=\w+`, "p@ss(("})
+	t.Cleanup(func() { logMasks = nil })
+
+	db := testDb(t, []string{"name"}, []string{"n-1"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	commands, err := render("echo token=secret123 p@ss((; exit 1", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{failed: 1}, execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"}))
+	require.Contains(t, logs.String(), "echo *** ***; exit 1")
+	require.NotContains(t, logs.String(), "secret123")
+	require.NotContains(t, logs.String(), "p@ss")
+}
+
+func TestBackup(t *testing.T) {
+	logs := captureLogs(t)
+	file := filepath.Join(t.TempDir(), "state.db")
+	db, err := NewLiteArgsDb(file)
+	require.Nil(t, err)
+	require.Nil(t, db.Init([]string{"name"}))
+	require.Nil(t, db.Insert([]string{"n-1"}))
+
+	path, err := backup(db, file, time.Date(2024, 8, 10, 23, 12, 54, 0, time.Local))
+	require.Nil(t, err)
+	require.Equal(t, file+".backup-20240810-231254", path)
+	snapshot, err := NewLiteArgsDb(path)
+	require.Nil(t, err)
+	rows, _, err := snapshot.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n-1"}}, rows)
+
+	path, err = backup(db, ":memory:", time.Now())
+	require.Nil(t, err)
+	require.Empty(t, path)
+	require.Contains(t, logs.String(), "backup skipped")
+}
+
+func TestGet(t *testing.T) {
+	db := testDb(t, []string{"name"}, []string{"n-1"})
+	require.Nil(t, db.Update(int64(1), LiteArgsDbUpdate{Succeed: true, Stdout: "hello\n", Time: time.Date(2024, 8, 10, 23, 12, 54, 0, time.UTC)}))
+	columns, row, err := db.Get(int64(1))
+	require.Nil(t, err)
+	require.Equal(t, []string{"rowid", "name"}, columns[:2])
+
+	var buffer bytes.Buffer
+	require.Nil(t, printRow(&buffer, columns, row, true))
+	var object map[string]any
+	require.Nil(t, json.Unmarshal(buffer.Bytes(), &object))
+	require.Equal(t, float64(1), object["rowid"])
+	require.Equal(t, "n-1", object["name"])
+	require.Equal(t, float64(1), object["succeed"])
+	require.Equal(t, "hello\n", object["last_stdout"])
+	require.Equal(t, "2024-08-10 23:12:54", object["last_attempt_dt"])
+
+	_, _, err = db.Get(int64(2))
+	require.NotNil(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"1"}, []string{"127"}, []string{"0"}, []string{"2"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{Take: 4, PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"})
+
+	var buffer bytes.Buffer
+	require.Nil(t, status(&buffer, db, true))
+	require.Equal(t, "total: 6, succeed: 1, failed: 3, pending: 2\nfailed exit 1: 2\nfailed exit 127: 1\nsucceed exit 0: 1\n", buffer.String())
+}
+
+func TestDistinct(t *testing.T) {
+	db := testDb(t, []string{"host", "path"}, []string{"a.com", "/1"}, []string{"b.com", "/2"}, []string{"a.com", "/3"}, []string{"c.com", "/4"})
+	require.Nil(t, db.InsertValues([]any{nil, "/5"}))
+
+	var buffer bytes.Buffer
+	require.Nil(t, distinct(&buffer, db, "host"))
+	require.Equal(t, "a.com: 2\nNULL: 1\nb.com: 1\nc.com: 1\n", buffer.String())
+
+	buffer.Reset()
+	require.Nil(t, distinct(&buffer, db, "succeed"))
+	require.Equal(t, "0: 5\n", buffer.String())
+
+	require.NotNil(t, distinct(io.Discard, db, "missing"))
+}
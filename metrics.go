@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// durationBuckets are upper bounds (in seconds) of the command duration histogram buckets
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// execMetrics are execution counters exposed in the Prometheus text format while exec runs
+type execMetrics struct {
+	completed atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+	running   atomic.Int64
+
+	lock sync.Mutex
+	// buckets count durations less or equal to the corresponding durationBuckets bound
+	buckets []int64
+	count   int64
+	sumSecs float64
+}
+
+func newExecMetrics() *execMetrics {
+	return &execMetrics{buckets: make([]int64, len(durationBuckets))}
+}
+
+// observe records completion of the command covering given amount of rows
+func (m *execMetrics) observe(succeed bool, rows int, duration time.Duration) {
+	m.completed.Add(int64(rows))
+	if succeed {
+		m.succeeded.Add(int64(rows))
+	} else {
+		m.failed.Add(int64(rows))
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sumSecs += seconds
+}
+
+func (m *execMetrics) write(w io.Writer) {
+	counters := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"liteargs_rows_completed_total", "counter", "Rows with completed command.", m.completed.Load()},
+		{"liteargs_rows_succeeded_total", "counter", "Rows with succeed command.", m.succeeded.Load()},
+		{"liteargs_rows_failed_total", "counter", "Rows with failed command.", m.failed.Load()},
+		{"liteargs_commands_running", "gauge", "Commands running at the moment.", m.running.Load()},
+	}
+	for _, counter := range counters {
+		_, _ = fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", counter.name, counter.help, counter.name, counter.kind, counter.name, counter.value)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, _ = fmt.Fprint(w, "# HELP liteargs_command_duration_seconds Duration of completed commands.\n# TYPE liteargs_command_duration_seconds histogram\n")
+	for i, bound := range durationBuckets {
+		_, _ = fmt.Fprintf(w, "liteargs_command_duration_seconds_bucket{le=\"%v\"} %v\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	_, _ = fmt.Fprintf(w, "liteargs_command_duration_seconds_bucket{le=\"+Inf\"} %v\n", m.count)
+	_, _ = fmt.Fprintf(w, "liteargs_command_duration_seconds_sum %v\n", strconv.FormatFloat(m.sumSecs, 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "liteargs_command_duration_seconds_count %v\n", m.count)
+}
+
+func (m *execMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// serveMetrics serves metrics on /metrics of the address in background and returns the bound address and function stopping the server
+func serveMetrics(addr string, metrics *execMetrics) (string, func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to listen metrics address %v: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errorLog("metrics server failed: %v", err)
+		}
+	}()
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			traceLog("failed to stop metrics server: %v", err)
+		}
+	}
+	return listener.Addr().String(), stop, nil
+}
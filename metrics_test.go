@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecMetrics(t *testing.T) {
+	captureLogs(t)
+	metrics := newExecMetrics()
+	addr, stop, err := serveMetrics("127.0.0.1:0", metrics)
+	require.Nil(t, err)
+	defer stop()
+	scrape := func() string {
+		response, err := http.Get("http://" + addr + "/metrics")
+		require.Nil(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		body, err := io.ReadAll(response.Body)
+		require.Nil(t, err)
+		return string(body)
+	}
+	require.Contains(t, scrape(), "liteargs_rows_completed_total 0\n")
+
+	db := testDb(t, []string{"code"}, []string{"0"}, []string{"1"}, []string{"0"})
+	rows, pks, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	commands, err := render("exit {{ .code }}", rows)
+	require.Nil(t, err)
+	summary := execute(context.Background(), db, pks, commands, execOptions{parallelism: 2, shell: "sh", metrics: metrics})
+	require.Equal(t, execSummary{succeed: 2, failed: 1}, summary)
+
+	body := scrape()
+	require.Contains(t, body, "liteargs_rows_completed_total 3\n")
+	require.Contains(t, body, "liteargs_rows_succeeded_total 2\n")
+	require.Contains(t, body, "liteargs_rows_failed_total 1\n")
+	require.Contains(t, body, "liteargs_commands_running 0\n")
+	require.Contains(t, body, "# TYPE liteargs_command_duration_seconds histogram\n")
+	require.Contains(t, body, "liteargs_command_duration_seconds_bucket{le=\"300\"} 3\n")
+	require.Contains(t, body, "liteargs_command_duration_seconds_bucket{le=\"+Inf\"} 3\n")
+	require.Contains(t, body, "liteargs_command_duration_seconds_count 3\n")
+
+	stop()
+	_, err = http.Get("http://" + addr + "/metrics")
+	require.NotNil(t, err)
+}
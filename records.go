@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// recordReader returns header as the first record followed by data records
+type recordReader interface {
+	Read() ([]string, error)
+	InputOffset() int64
+}
+
+// nullRecordReader is implemented by readers which distinguish NULL values from empty strings
+type nullRecordReader interface {
+	// Nulls reports which values of the last read record are NULL
+	Nulls() []bool
+}
+
+var formatExtensions = map[string]string{
+	".csv":    "csv",
+	".tsv":    "tsv",
+	".json":   "json",
+	".jsonl":  "jsonl",
+	".ndjson": "jsonl",
+}
+
+// inputFormat resolves auto format from the input file extension falling back to CSV
+func inputFormat(format string, file string) (string, error) {
+	switch format {
+	case "csv", "tsv", "json", "jsonl", "nul":
+		return format, nil
+	case "auto":
+		if parsed, err := url.Parse(file); err == nil && isURL(file) {
+			file = parsed.Path
+		}
+		file = strings.TrimSuffix(strings.ToLower(file), ".gz")
+		if resolved, ok := formatExtensions[filepath.Ext(file)]; ok {
+			return resolved, nil
+		}
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("unsupported input format: '%v'", format)
+	}
+}
+
+// csvDialect is a bundle of CSV reading conventions; line endings (both \n and \r\n) are always accepted by the reader
+type csvDialect struct {
+	sep rune
+	// lazyQuotes accepts quotes inside unquoted fields and non-doubled quotes inside quoted fields
+	lazyQuotes bool
+	// trimLeadingSpace ignores leading white space of every field
+	trimLeadingSpace bool
+}
+
+// csvDialects are named presets similar to Python csv dialects
+var csvDialects = map[string]csvDialect{
+	// excel is strict RFC 4180 CSV as written by spreadsheets (usually with \r\n line endings)
+	"excel": {sep: ','},
+	// unix is CSV produced by ad-hoc tools and scripts which don't always escape quotes properly
+	"unix": {sep: ',', lazyQuotes: true},
+	// pipe is pipe separated table like "a | b | c" aligned with spaces
+	"pipe": {sep: '|', lazyQuotes: true, trimLeadingSpace: true},
+	// semicolon is CSV written by spreadsheets in locales where comma is a decimal separator
+	"semicolon": {sep: ';'},
+}
+
+func dialect(name string) (csvDialect, error) {
+	preset, ok := csvDialects[name]
+	if !ok {
+		return csvDialect{}, fmt.Errorf("unsupported dialect: '%v', expected one of excel, unix, pipe, semicolon", name)
+	}
+	return preset, nil
+}
+
+// newRecordReader creates reader for the format; known header is used when JSON reading starts in the middle of the input
+func newRecordReader(format string, reader io.Reader, dialect csvDialect, header []string) recordReader {
+	switch format {
+	case "json", "jsonl":
+		decoder := json.NewDecoder(reader)
+		decoder.UseNumber()
+		return &jsonRecordReader{decoder: decoder, array: format == "json", header: header}
+	case "nul":
+		return &nulRecordReader{reader: bufio.NewReader(reader)}
+	case "tsv":
+		dialect.sep = '\t'
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = dialect.sep
+	csvReader.LazyQuotes = dialect.lazyQuotes
+	csvReader.TrimLeadingSpace = dialect.trimLeadingSpace
+	return csvReader
+}
+
+// columnTypes are SQLite types which can be declared for the user columns
+var columnTypes = []string{"INTEGER", "REAL", "TEXT", "NUMERIC", "BLOB"}
+
+// parseTypesDirective parses column types from the metadata line like "#types: size=INTEGER, ratio=REAL"
+func parseTypesDirective(prefix string, fields []string) (map[string]string, error) {
+	directive := strings.TrimPrefix(strings.Join(fields, ","), prefix)
+	pairs := strings.FieldsFunc(directive, func(r rune) bool {
+		return r == ',' || r == ';' || r == '|' || unicode.IsSpace(r)
+	})
+	types := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		column, columnType, ok := strings.Cut(pair, "=")
+		columnType = strings.ToUpper(columnType)
+		if !ok || column == "" || !slices.Contains(columnTypes, columnType) {
+			return nil, fmt.Errorf("invalid column type '%v' in %v directive, expected column=TYPE with one of types: %v", pair, prefix, strings.Join(columnTypes, ", "))
+		}
+		types[column] = columnType
+	}
+	return types, nil
+}
+
+// lineLimitReader fails on the input line longer than limit bytes
+type lineLimitReader struct {
+	reader io.Reader
+	limit  int
+	// line is the number of the current line and length is amount of its bytes read so far
+	line   int
+	length int
+}
+
+func (r *lineLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			r.line, r.length = r.line+1, 0
+			continue
+		}
+		if r.length++; r.length > r.limit {
+			return i, fmt.Errorf("input line %v exceeds maximum size of %v bytes", r.line, r.limit)
+		}
+	}
+	return n, err
+}
+
+// nulRecordReader reads NUL-terminated tokens (e.g. output of find -print0) as single-column records
+type nulRecordReader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func (r *nulRecordReader) InputOffset() int64 {
+	return r.offset
+}
+
+func (r *nulRecordReader) Read() ([]string, error) {
+	token, err := r.reader.ReadString(0)
+	r.offset += int64(len(token))
+	// trailing newline after the last NUL (e.g. find -print0; echo) isn't a record
+	if errors.Is(err, io.EOF) && strings.TrimSpace(token) != "" {
+		return []string{token}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []string{strings.TrimSuffix(token, "\x00")}, nil
+}
+
+// jsonRecordReader reads flat JSON objects either from the JSON array or from the JSON lines; keys of the first object form the header
+type jsonRecordReader struct {
+	decoder      *json.Decoder
+	array        bool
+	started      bool
+	header       []string
+	pending      []string
+	pendingNulls []bool
+	nulls        []bool
+}
+
+func (r *jsonRecordReader) Nulls() []bool {
+	return r.nulls
+}
+
+func (r *jsonRecordReader) InputOffset() int64 {
+	return r.decoder.InputOffset()
+}
+
+func (r *jsonRecordReader) Read() ([]string, error) {
+	if r.pending != nil {
+		record := r.pending
+		r.pending, r.nulls, r.pendingNulls = nil, r.pendingNulls, nil
+		return record, nil
+	}
+	r.nulls = nil
+	if r.array && !r.started {
+		if err := r.expectDelim('['); err != nil {
+			return nil, err
+		}
+		r.started = true
+	}
+	if r.array && !r.decoder.More() {
+		return nil, io.EOF
+	}
+	keys, values, err := r.readObject()
+	if err != nil {
+		return nil, err
+	}
+	if r.header == nil {
+		r.header = keys
+		r.pending, r.pendingNulls, err = r.record(values)
+		if err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	record, nulls, err := r.record(values)
+	r.nulls = nulls
+	return record, err
+}
+
+func (r *jsonRecordReader) record(values map[string]any) ([]string, []bool, error) {
+	for key := range values {
+		if !slices.Contains(r.header, key) {
+			return nil, nil, fmt.Errorf("json object has key '%v' which is not present in the header %v", key, r.header)
+		}
+	}
+	record, nulls := make([]string, len(r.header)), make([]bool, len(r.header))
+	for i, key := range r.header {
+		value, ok := values[key]
+		if nulls[i] = !ok || value == nil; nulls[i] {
+			continue
+		}
+		var err error
+		if record[i], err = jsonString(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return record, nulls, nil
+}
+
+func (r *jsonRecordReader) expectDelim(delim json.Delim) error {
+	token, err := r.decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected '%v' in json input, got: %v", delim, token)
+	}
+	return nil
+}
+
+func (r *jsonRecordReader) readObject() ([]string, map[string]any, error) {
+	if err := r.expectDelim('{'); err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0)
+	values := make(map[string]any)
+	for r.decoder.More() {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := token.(string)
+		var value any
+		if err = r.decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	if err := r.expectDelim('}'); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+func jsonString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode json value: %w", err)
+		}
+		return string(encoded), nil
+	}
+}
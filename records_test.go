@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputFormat(t *testing.T) {
+	for _, tt := range []struct {
+		format   string
+		file     string
+		expected string
+	}{
+		{format: "auto", file: "data.csv", expected: "csv"},
+		{format: "auto", file: "data.TSV", expected: "tsv"},
+		{format: "auto", file: "data.json", expected: "json"},
+		{format: "auto", file: "data.jsonl", expected: "jsonl"},
+		{format: "auto", file: "data.ndjson", expected: "jsonl"},
+		{format: "auto", file: "data.txt", expected: "csv"},
+		{format: "auto", file: "", expected: "csv"},
+		{format: "jsonl", file: "data.csv", expected: "jsonl"},
+	} {
+		t.Run(tt.format+"/"+tt.file, func(t *testing.T) {
+			format, err := inputFormat(tt.format, tt.file)
+			require.Nil(t, err)
+			require.Equal(t, tt.expected, format)
+		})
+	}
+	_, err := inputFormat("xml", "data.xml")
+	require.NotNil(t, err)
+}
+
+func TestLoadFormats(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		input  string
+		url    any
+	}{
+		{format: "tsv", input: "name\turl\nn-1\thttps://google.com\nn-2\t\n", url: ""},
+		{format: "json", input: `[{"name": "n-1", "url": "https://google.com"}, {"url": null, "name": "n-2"}]`, url: nil},
+		{format: "json", input: `[{"name": "n-1", "url": "https://google.com"}, {"url": "", "name": "n-2"}]`, url: ""},
+		{format: "jsonl", input: "{\"name\": \"n-1\", \"url\": \"https://google.com\"}\n{\"name\": \"n-2\"}\n", url: nil},
+	} {
+		t.Run(fmt.Sprintf("%v/url=%v", tt.format, tt.url), func(t *testing.T) {
+			db := testDb(t, nil)
+			recordNumber, err := load(db, strings.NewReader(tt.input), loadOptions{sep: ',', format: tt.format})
+			require.Nil(t, err)
+			require.Equal(t, 2, recordNumber)
+			rows, _, err := db.Filter(LiteArgsDbFilter{})
+			require.Nil(t, err)
+			require.Equal(t, []map[string]any{
+				{"rowid": int64(1), "name": "n-1", "url": "https://google.com"},
+				{"rowid": int64(2), "name": "n-2", "url": tt.url},
+			}, rows)
+		})
+	}
+}
+
+func TestJsonRecordReaderValues(t *testing.T) {
+	reader := newRecordReader("jsonl", strings.NewReader(`{"n": 1.5, "b": true, "o": {"k": [1, 2]}}`+"\n"+`{"n": 2, "extra": 1}`), csvDialect{sep: ','}, nil)
+	header, err := reader.Read()
+	require.Nil(t, err)
+	require.Equal(t, []string{"n", "b", "o"}, header)
+	record, err := reader.Read()
+	require.Nil(t, err)
+	require.Equal(t, []string{"1.5", "true", `{"k":[1,2]}`}, record)
+	_, err = reader.Read()
+	require.NotNil(t, err)
+
+	reader = newRecordReader("jsonl", strings.NewReader(`{"n": null, "s": ""}`+"\n"+`{"s": null}`), csvDialect{sep: ','}, nil)
+	for _, expected := range [][]bool{nil, {true, false}, {true, true}} {
+		_, err = reader.Read()
+		require.Nil(t, err)
+		require.Equal(t, expected, reader.(nullRecordReader).Nulls())
+	}
+}
+
+func TestLoadNullDelimited(t *testing.T) {
+	db := testDb(t, nil)
+	recordNumber, err := load(db, strings.NewReader("./a b.txt\x00./c,\"d\".txt\x00./e\nf.txt\x00"), loadOptions{format: "nul", noHeader: true})
+	require.Nil(t, err)
+	require.Equal(t, 3, recordNumber)
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "arg0": "./a b.txt"},
+		{"rowid": int64(2), "arg0": "./c,\"d\".txt"},
+		{"rowid": int64(3), "arg0": "./e\nf.txt"},
+	}, rows)
+
+	recordNumber, err = load(db, strings.NewReader("./g.txt\x00./h.txt\x00"), loadOptions{format: "nul", noInit: true})
+	require.Nil(t, err)
+	require.Equal(t, 2, recordNumber)
+	rows, _, err = db.Filter(LiteArgsDbFilter{RowIds: []int64{4, 5}})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(4), "arg0": "./g.txt"},
+		{"rowid": int64(5), "arg0": "./h.txt"},
+	}, rows)
+
+	reader := newRecordReader("nul", strings.NewReader("path\x00last"), csvDialect{}, nil)
+	for _, expected := range []string{"path", "last"} {
+		record, err := reader.Read()
+		require.Nil(t, err)
+		require.Equal(t, []string{expected}, record)
+	}
+	require.Equal(t, int64(9), reader.InputOffset())
+
+	reader = newRecordReader("nul", strings.NewReader("./a.txt\x00./b.txt\x00\n"), csvDialect{}, nil)
+	for _, expected := range []string{"./a.txt", "./b.txt"} {
+		record, err := reader.Read()
+		require.Nil(t, err)
+		require.Equal(t, []string{expected}, record)
+	}
+	_, err = reader.Read()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestDialects(t *testing.T) {
+	for _, tt := range []struct {
+		dialect  string
+		input    string
+		expected [][]string
+	}{
+		{dialect: "excel", input: "name,note\r\nn-1,\"a, \"\"b\"\"\"\r\n", expected: [][]string{{"name", "note"}, {"n-1", `a, "b"`}}},
+		{dialect: "unix", input: "name,note\nn-1,say \"hi\"\n", expected: [][]string{{"name", "note"}, {"n-1", `say "hi"`}}},
+		{dialect: "pipe", input: "name | note\nn-1 | a,b\n", expected: [][]string{{"name ", "note"}, {"n-1 ", "a,b"}}},
+		{dialect: "semicolon", input: "name;price\nn-1;1,5\n", expected: [][]string{{"name", "price"}, {"n-1", "1,5"}}},
+	} {
+		t.Run(tt.dialect, func(t *testing.T) {
+			preset, err := dialect(tt.dialect)
+			require.Nil(t, err)
+			reader := newRecordReader("csv", strings.NewReader(tt.input), preset, nil)
+			for _, expected := range tt.expected {
+				record, err := reader.Read()
+				require.Nil(t, err)
+				require.Equal(t, expected, record)
+			}
+			_, err = reader.Read()
+			require.ErrorIs(t, err, io.EOF)
+		})
+	}
+	strict := newRecordReader("csv", strings.NewReader("name,note\nn-1,say \"hi\"\n"), csvDialects["excel"], nil)
+	_, err := strict.Read()
+	require.Nil(t, err)
+	_, err = strict.Read()
+	require.NotNil(t, err)
+	_, err = dialect("tsv")
+	require.NotNil(t, err)
+}
+
+func TestLoadMaxLineSize(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	input := "name,note\nn-1,\"multi\nline\"\n" + strings.Repeat("x", 100) + "\nn-3,ok\n"
+	_, err := load(db, strings.NewReader(input), loadOptions{sep: ',', maxLineSize: 64})
+	require.ErrorContains(t, err, "input line 4 exceeds maximum size of 64 bytes")
+
+	recordNumber, err := load(db, strings.NewReader("name,note\nn-1,a\nn-2,b\n"), loadOptions{sep: ',', maxLineSize: 9, noInit: true})
+	require.Nil(t, err)
+	require.Equal(t, 2, recordNumber)
+}
+
+func TestSeparator(t *testing.T) {
+	for _, tt := range []struct {
+		s        string
+		expected rune
+	}{
+		{s: ",", expected: ','},
+		{s: "\\t", expected: '\t'},
+		{s: "tab", expected: '\t'},
+		{s: "pipe", expected: '|'},
+		{s: "semicolon", expected: ';'},
+		{s: "space", expected: ' '},
+	} {
+		t.Run(tt.s, func(t *testing.T) { require.Equal(t, tt.expected, separator(tt.s)) })
+	}
+}
+
+func TestLoadUseColumns(t *testing.T) {
+	db := testDb(t, nil)
+	recordNumber, err := load(db, strings.NewReader("id,name,url,comment\n1,n-1,https://google.com,search\n2,n-2,https://turso.tech,db\n"), loadOptions{
+		sep:        ',',
+		useColumns: []string{"name", "url"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, recordNumber)
+	require.Equal(t, []string{"rowid", "name", "url"}, db.Columns())
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "name": "n-1", "url": "https://google.com"},
+		{"rowid": int64(2), "name": "n-2", "url": "https://turso.tech"},
+	}, rows)
+
+	_, err = load(db, strings.NewReader("id,name\n1,n-1\n"), loadOptions{sep: ',', useColumns: []string{"url"}})
+	require.NotNil(t, err)
+}
+
+func TestLoadMaxRows(t *testing.T) {
+	logs := captureLogs(t)
+	for _, noHeader := range []bool{false, true} {
+		db := testDb(t, nil)
+		recordNumber, err := load(db, strings.NewReader("name\nn-1\nn-2\nn-3\n"), loadOptions{sep: ',', noHeader: noHeader, maxRows: 2})
+		require.Nil(t, err)
+		require.Equal(t, 2, recordNumber)
+		rows, _, err := db.Filter(LiteArgsDbFilter{})
+		require.Nil(t, err)
+		require.Len(t, rows, 2)
+	}
+	require.Equal(t, 2, strings.Count(logs.String(), "reached maximum of 2 rows"))
+}
+
+func TestLoadMeta(t *testing.T) {
+	db := testDb(t, []string{"name"})
+	loadTime := time.Date(2024, 8, 10, 23, 12, 54, 0, time.UTC)
+	require.Nil(t, recordLoadMeta(db, []string{"liteargs", "load", "state.db", "-i", "urls.csv"}, "tab", "urls.csv", loadTime))
+	meta, err := db.Meta()
+	require.Nil(t, err)
+	require.Equal(t, []LiteArgsDbMeta{
+		{Key: "load_command", Value: "liteargs load state.db -i urls.csv"},
+		{Key: "load_dt", Value: "2024-08-10 23:12:54"},
+		{Key: "load_separator", Value: "tab"},
+		{Key: "load_source", Value: "urls.csv"},
+	}, meta)
+
+	require.Nil(t, recordLoadMeta(db, []string{"liteargs", "load", "state.db"}, ",", "", loadTime))
+	meta, err = db.Meta()
+	require.Nil(t, err)
+	require.Contains(t, meta, LiteArgsDbMeta{Key: "load_source", Value: "stdin"})
+	require.Len(t, meta, 4)
+}
+
+func TestLoadEmptyAsNull(t *testing.T) {
+	db := testDb(t, nil)
+	_, err := load(db, strings.NewReader("name,url\nn-1,\nn-2,https://turso.tech\n"), loadOptions{sep: ',', emptyNull: true})
+	require.Nil(t, err)
+	rows, _, err := db.Filter(LiteArgsDbFilter{Filter: "url IS NULL"})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n-1", "url": nil}}, rows)
+}
+
+func TestLoadResume(t *testing.T) {
+	captureLogs(t)
+	inputFile := filepath.Join(t.TempDir(), "input.csv")
+	require.Nil(t, os.WriteFile(inputFile, []byte("name,url\nn-1,\"https://google.com\"\nn-2,\"https://example\n.com\"\nn-3,https://turso.tech\nn-4,https://sqlite.org\n"), 0644))
+	db := testDb(t, nil)
+
+	loadFile := func(options loadOptions) int {
+		file, err := os.Open(inputFile)
+		require.Nil(t, err)
+		defer file.Close()
+		recordNumber, err := load(db, file, options)
+		require.Nil(t, err)
+		return recordNumber
+	}
+	require.Equal(t, 2, loadFile(loadOptions{sep: ',', maxRows: 2}))
+	offset, line, err := loadProgress(db)
+	require.Nil(t, err)
+	require.Equal(t, 3, line)
+	require.Equal(t, int64(len("name,url\nn-1,\"https://google.com\"\nn-2,\"https://example\n.com\"\n")), offset)
+
+	require.Equal(t, 2, loadFile(loadOptions{sep: ',', resume: true}))
+	rows, _, err := db.Filter(LiteArgsDbFilter{PreserveOrder: true})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "name": "n-1", "url": "https://google.com"},
+		{"rowid": int64(2), "name": "n-2", "url": "https://example\n.com"},
+		{"rowid": int64(3), "name": "n-3", "url": "https://turso.tech"},
+		{"rowid": int64(4), "name": "n-4", "url": "https://sqlite.org"},
+	}, rows)
+
+	require.Equal(t, 0, loadFile(loadOptions{sep: ',', resume: true}))
+	_, err = load(db, bytes.NewBufferString("name,url\n"), loadOptions{sep: ',', resume: true})
+	require.NotNil(t, err)
+
+	// resume is refused for a different file with the same content and for the modified file
+	require.Nil(t, db.Reset("", "", false))
+	_, err = db.Delete(LiteArgsDbFilter{WhereRaw: "1 = 1"})
+	require.Nil(t, err)
+	require.Equal(t, 2, loadFile(loadOptions{sep: ',', maxRows: 2}))
+	content, err := os.ReadFile(inputFile)
+	require.Nil(t, err)
+	otherFile := filepath.Join(t.TempDir(), "input.csv")
+	require.Nil(t, os.WriteFile(otherFile, content, 0644))
+	other, err := os.Open(otherFile)
+	require.Nil(t, err)
+	defer other.Close()
+	_, err = load(db, other, loadOptions{sep: ',', resume: true})
+	require.ErrorContains(t, err, "input doesn't match the interrupted load")
+
+	require.Nil(t, os.WriteFile(inputFile, append(content, "n-5,https://go.dev\n"...), 0644))
+	file, err := os.Open(inputFile)
+	require.Nil(t, err)
+	defer file.Close()
+	_, err = load(db, file, loadOptions{sep: ',', resume: true})
+	require.ErrorContains(t, err, "input doesn't match the interrupted load")
+}
+
+func TestLoadNoInit(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	_, err := load(db, strings.NewReader("name,url\nn-1,https://google.com\n"), loadOptions{sep: ',', noInit: true})
+	require.NotNil(t, err)
+
+	_, err = load(db, strings.NewReader("name,url\nn-1,https://google.com\n"), loadOptions{sep: ','})
+	require.Nil(t, err)
+	recordNumber, err := load(db, strings.NewReader("url,name\nhttps://turso.tech,n-2\n"), loadOptions{sep: ',', noInit: true})
+	require.Nil(t, err)
+	require.Equal(t, 1, recordNumber)
+	recordNumber, err = load(db, strings.NewReader("id,url,name\n3,https://sqlite.org,n-3\n"), loadOptions{sep: ',', noInit: true, useColumns: []string{"url", "name"}})
+	require.Nil(t, err)
+	require.Equal(t, 1, recordNumber)
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "name": "n-1", "url": "https://google.com"},
+		{"rowid": int64(2), "name": "n-2", "url": "https://turso.tech"},
+		{"rowid": int64(3), "name": "n-3", "url": "https://sqlite.org"},
+	}, rows)
+
+	_, err = load(db, strings.NewReader("name,url,comment\nn-4,https://example.com,test\n"), loadOptions{sep: ',', noInit: true})
+	require.NotNil(t, err)
+	_, err = load(db, strings.NewReader("name,link\nn-4,https://example.com\n"), loadOptions{sep: ',', noInit: true})
+	require.NotNil(t, err)
+}
+
+func TestLoadStrictSchema(t *testing.T) {
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := NewLiteArgsDb(path)
+	require.Nil(t, err)
+	_, err = load(db, strings.NewReader("name,url\nn-1,https://google.com\n"), loadOptions{sep: ',', strictSchema: true})
+	require.Nil(t, err)
+
+	db, err = NewLiteArgsDb(path)
+	require.Nil(t, err)
+	_, err = load(db, strings.NewReader("url,name\nhttps://turso.tech,n-2\n"), loadOptions{sep: ',', strictSchema: true})
+	require.ErrorContains(t, err, "column 1: table has 'name', input has 'url'")
+	require.ErrorContains(t, err, "column 2: table has 'url', input has 'name'")
+
+	recordNumber, err := load(db, strings.NewReader("name,url\nn-2,https://turso.tech\n"), loadOptions{sep: ',', strictSchema: true})
+	require.Nil(t, err)
+	require.Equal(t, 1, recordNumber)
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Len(t, rows, 2)
+}
+
+func TestLoadWithUUID(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	recordNumber, err := load(db, strings.NewReader("id,name\n1,n-1\n2,n-2\n3,n-3\n"), loadOptions{sep: ',', withUUID: true, useColumns: []string{"name"}})
+	require.Nil(t, err)
+	require.Equal(t, 3, recordNumber)
+	require.Equal(t, []string{"rowid", "name", "uuid"}, db.Columns())
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	uuids := make(map[any]struct{})
+	for _, row := range rows {
+		require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, row["uuid"])
+		uuids[row["uuid"]] = struct{}{}
+	}
+	require.Len(t, uuids, 3)
+
+	commands, err := render("echo {{ .uuid }}", rows[:1])
+	require.Nil(t, err)
+	require.Equal(t, []string{fmt.Sprintf("echo %v", rows[0]["uuid"])}, commands)
+
+	_, err = load(db, strings.NewReader("uuid,name\n1,n-1\n"), loadOptions{sep: ',', withUUID: true})
+	require.NotNil(t, err)
+}
+
+func TestLoadTypesDirective(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	input := "#types: size=INTEGER, ratio=real\nname,size,ratio\na,10,0.5\nb,9,1.5\nc,100,2\n"
+	loaded, err := load(db, strings.NewReader(input), loadOptions{sep: ',', typesDirective: "#types:"})
+	require.Nil(t, err)
+	require.Equal(t, 3, loaded)
+	require.Equal(t, []string{"rowid", "name", "size", "ratio"}, db.Columns())
+
+	schema, err := db.Schema()
+	require.Nil(t, err)
+	require.Contains(t, schema.Statements[0], "name, size INTEGER, ratio REAL")
+	rows, _, err := db.Filter(LiteArgsDbFilter{Order: "size ASC"})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(2), "name": "b", "size": int64(9), "ratio": 1.5},
+		{"rowid": int64(1), "name": "a", "size": int64(10), "ratio": 0.5},
+		{"rowid": int64(3), "name": "c", "size": int64(100), "ratio": 2.0},
+	}, rows)
+
+	db = testDb(t, nil)
+	_, err = load(db, strings.NewReader("#types: size=INTEGER\nname\na\n"), loadOptions{sep: ',', typesDirective: "#types:"})
+	require.ErrorContains(t, err, "column size from #types: directive not found")
+	_, err = load(db, strings.NewReader("#types: size=DATE\nsize\n1\n"), loadOptions{sep: ',', typesDirective: "#types:"})
+	require.ErrorContains(t, err, "invalid column type 'size=DATE'")
+}
+
+func TestLoadWithSeq(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	_, err := load(db, strings.NewReader("name\nn-1\nn-2\nn-3\n"), loadOptions{sep: ',', withSeq: true})
+	require.Nil(t, err)
+	require.Equal(t, []string{"rowid", "name", "seq"}, db.Columns())
+
+	deleted, err := db.Delete(LiteArgsDbFilter{WhereRaw: "name = 'n-3'"})
+	require.Nil(t, err)
+	require.Equal(t, 1, deleted)
+	for i := 4; i <= 11; i++ {
+		_, err = load(db, strings.NewReader(fmt.Sprintf("name\nn-%v\n", i)), loadOptions{sep: ',', withSeq: true})
+		require.Nil(t, err)
+	}
+
+	rows, _, err := db.Filter(LiteArgsDbFilter{Order: "seq DESC", Take: 3})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(10), "name": "n-11", "seq": int64(10)},
+		{"rowid": int64(9), "name": "n-10", "seq": int64(9)},
+		{"rowid": int64(8), "name": "n-9", "seq": int64(8)},
+	}, rows)
+	commands, err := render("echo {{ .seq }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, []string{"echo 10", "echo 9", "echo 8"}, commands)
+
+	_, err = load(db, strings.NewReader("seq\n1\n"), loadOptions{sep: ',', withSeq: true})
+	require.NotNil(t, err)
+}
+
+func TestLoadTag(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	_, err := load(db, strings.NewReader("name\nn-1\nn-2\n"), loadOptions{sep: ',', tag: "batch-1"})
+	require.Nil(t, err)
+	_, err = load(db, strings.NewReader("name\nn-3\n"), loadOptions{sep: ',', tag: "batch-'2'"})
+	require.Nil(t, err)
+
+	rows, pks, err := db.Filter(LiteArgsDbFilter{Tag: "batch-'2'"})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(3), "name": "n-3"}}, rows)
+	commands, err := render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 1}, execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"}))
+
+	rows, pks, err = db.Filter(LiteArgsDbFilter{Tag: "batch-1"})
+	require.Nil(t, err)
+	require.Len(t, rows, 2)
+	commands, err = render("echo {{ .name }}", rows)
+	require.Nil(t, err)
+	require.Equal(t, execSummary{succeed: 2}, execute(context.Background(), db, pks, commands, execOptions{parallelism: 1, shell: "sh"}))
+
+	require.Nil(t, db.Reset("batch-1", "", false))
+	rows, _, err = db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{{"rowid": int64(1), "name": "n-1"}, {"rowid": int64(2), "name": "n-2"}}, rows)
+}
+
+func TestLoadURL(t *testing.T) {
+	captureLogs(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/urls.csv":
+			_, _ = w.Write([]byte("name,url\nn-1,https://google.com\n"))
+		case "/urls.jsonl.gz":
+			writer := gzip.NewWriter(w)
+			_, _ = writer.Write([]byte(`{"name": "n-2", "url": "https://turso.tech"}` + "\n"))
+			_ = writer.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	db := testDb(t, nil)
+	for _, source := range []struct {
+		path    string
+		gzipped bool
+	}{{path: "/urls.csv"}, {path: "/urls.jsonl.gz", gzipped: true}} {
+		format, err := inputFormat("auto", server.URL+source.path+"?token=1")
+		require.Nil(t, err)
+		reader, err := input(context.Background(), server.URL+source.path, source.gzipped)
+		require.Nil(t, err)
+		recordNumber, err := load(db, reader, loadOptions{sep: ',', format: format})
+		require.Nil(t, err)
+		require.Equal(t, 1, recordNumber)
+		require.Nil(t, reader.Close())
+	}
+	rows, _, err := db.Filter(LiteArgsDbFilter{})
+	require.Nil(t, err)
+	require.Equal(t, []map[string]any{
+		{"rowid": int64(1), "name": "n-1", "url": "https://google.com"},
+		{"rowid": int64(2), "name": "n-2", "url": "https://turso.tech"},
+	}, rows)
+
+	_, err = input(context.Background(), server.URL+"/missing.csv", false)
+	require.ErrorContains(t, err, "unexpected status 404 Not Found")
+}
+
+func loadInput(records int) string {
+	var input strings.Builder
+	input.WriteString("id,name\n")
+	for i := 0; i < records; i++ {
+		_, _ = fmt.Fprintf(&input, "%v,n-%v\n", i, i)
+	}
+	return input.String()
+}
+
+func TestLoadParallelism(t *testing.T) {
+	captureLogs(t)
+	db := testDb(t, nil)
+	recordNumber, err := load(db, strings.NewReader(loadInput(5*loadBatchSize+7)), loadOptions{sep: ',', parallelism: 4})
+	require.Nil(t, err)
+	require.Equal(t, 5*loadBatchSize+7, recordNumber)
+	stats, err := db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, 5*loadBatchSize+7, stats.Total)
+	var distinct int
+	require.Nil(t, db.db.QueryRow(`SELECT COUNT(DISTINCT id) FROM liteargs`).Scan(&distinct))
+	require.Equal(t, 5*loadBatchSize+7, distinct)
+
+	_, err = load(db, strings.NewReader(loadInput(1)), loadOptions{sep: ',', parallelism: 4, resume: true})
+	require.NotNil(t, err)
+}
+
+func BenchmarkLoadParallelism(b *testing.B) {
+	logWriter = io.Discard
+	b.Cleanup(func() { logWriter = os.Stderr })
+	input := loadInput(20 * loadBatchSize)
+	for _, parallelism := range []int{1, 4} {
+		b.Run(fmt.Sprintf("parallelism=%v", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				db, err := NewLiteArgsDb(filepath.Join(b.TempDir(), "state.db"))
+				require.Nil(b, err)
+				_, err = load(db, strings.NewReader(input), loadOptions{sep: ',', parallelism: parallelism})
+				require.Nil(b, err)
+			}
+		})
+	}
+}
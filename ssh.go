@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHExecutor runs commands on the remote host over SSH
+type SSHExecutor struct {
+	Addr   string
+	Config *ssh.ClientConfig
+	// Env contains KEY=VALUE pairs set for the remote command and Dir is its remote working directory
+	Env            []string
+	Dir            string
+	MaxOutputBytes int
+}
+
+func sshConfig(user, keyFile, knownHostsFile string, insecure bool) (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh key: %w", err)
+	}
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !insecure {
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh known hosts: %w", err)
+		}
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// sshAddr appends default SSH port to the host if it has no explicit port
+func sshAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "22")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sshExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+// remoteCommand returns command line executed by the remote shell with the working directory and environment applied
+func (e SSHExecutor) remoteCommand(shell string, command string) string {
+	remote := fmt.Sprintf("%v -c %v", shell, shellQuote(command))
+	if len(e.Env) > 0 {
+		pairs := make([]string, len(e.Env))
+		for i, pair := range e.Env {
+			pairs[i] = shellQuote(pair)
+		}
+		remote = fmt.Sprintf("env %v %v", strings.Join(pairs, " "), remote)
+	}
+	if e.Dir != "" {
+		remote = fmt.Sprintf("cd %v && %v", shellQuote(e.Dir), remote)
+	}
+	return remote
+}
+
+func (e SSHExecutor) Run(ctx context.Context, shell string, command string) CommandResult {
+	startTime := time.Now()
+	client, err := ssh.Dial("tcp", e.Addr, e.Config)
+	if err != nil {
+		errorLog("failed to connect to ssh host: %v, err=%v", e.Addr, err)
+		return CommandResult{ExitCode: -1}
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		errorLog("failed to open ssh session: %v, err=%v", e.Addr, err)
+		return CommandResult{ExitCode: -1}
+	}
+	defer session.Close()
+
+	stdout := newOutputBuffer(e.MaxOutputBytes)
+	stderr := newOutputBuffer(e.MaxOutputBytes)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	err = session.Start(e.remoteCommand(shell, command))
+	if err != nil {
+		errorLog("failed to execute command: %v, host=%v, err=%v", command, e.Addr, err)
+		return CommandResult{ExitCode: -1}
+	}
+	traceLog("command started: %v, host=%v", command, e.Addr)
+
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- session.Wait() }()
+
+	result := CommandResult{ExitCode: -1}
+	select {
+	case err = <-waitCh:
+		result.ExitCode = sshExitCode(err)
+		if err == nil {
+			result.Succeed = true
+			okLog("command succeed: %v, host=%v, elapsed=%v, stdout=%v", command, e.Addr, time.Since(startTime), stdout.String())
+		} else {
+			errorLog("command failed: %v, host=%v, err=%v", command, e.Addr, err)
+		}
+	case <-ctx.Done():
+		traceLog("command interrupted: %v, host=%v", command, e.Addr)
+		result.Interrupted = ctx.Err()
+		err = session.Signal(ssh.SIGINT)
+		if err != nil {
+			traceLog("command interruption failed: %v, host=%v, err=%v", command, e.Addr, err)
+		}
+		_ = client.Close()
+		<-waitCh
+	}
+	result.Duration = time.Since(startTime)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer starts SSH server which executes commands locally and accepts only given client key
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) string {
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	require.Nil(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					_ = request.Reply(false, nil)
+					continue
+				}
+				_ = request.Reply(true, nil)
+				cmd := exec.Command("sh", "-c", string(request.Payload[4:]))
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(exitCode(cmd.Run())))
+				_, _ = channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func TestSSHExecutor(t *testing.T) {
+	captureLogs(t)
+	clientPublicKey, clientPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	sshPublicKey, err := ssh.NewPublicKey(clientPublicKey)
+	require.Nil(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPrivateKey, "")
+	require.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+
+	addr := startSSHServer(t, sshPublicKey)
+	config, err := sshConfig("liteargs", keyFile, "", true)
+	require.Nil(t, err)
+	executor := SSHExecutor{Addr: sshAddr(addr), Config: config}
+
+	result := executor.Run(context.Background(), "sh", "echo 'it''s remote'; echo oops >&2")
+	require.True(t, result.Succeed)
+	require.Equal(t, 0, result.ExitCode)
+	require.Equal(t, "its remote\n", result.Stdout)
+	require.Equal(t, "oops\n", result.Stderr)
+
+	result = executor.Run(context.Background(), "sh", "exit 3")
+	require.False(t, result.Succeed)
+	require.Equal(t, 3, result.ExitCode)
+
+	dir := t.TempDir()
+	executor.Env, executor.Dir = []string{"LITEARGS_TEST=it's set"}, dir
+	require.Equal(t, "cd '"+dir+"' && env 'LITEARGS_TEST=it'\\''s set' sh -c 'pwd'", executor.remoteCommand("sh", "pwd"))
+	result = executor.Run(context.Background(), "sh", "echo $LITEARGS_TEST; pwd")
+	require.True(t, result.Succeed)
+	require.Equal(t, "it's set\n"+dir+"\n", result.Stdout)
+
+	require.Equal(t, "example.com:22", sshAddr("example.com"))
+	require.Equal(t, "example.com:2222", sshAddr("example.com:2222"))
+}